@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"go.universe.tf/iris/internal/location"
+)
+
+var benchTime = flag.Duration("bench-time", 5*time.Second, "bench: how long to run each scenario for")
+
+// A benchScenario is a standard workload for the bench command.
+type benchScenario struct {
+	Name string
+	// Size is the size of the synthetic input image.
+	Size image.Point
+}
+
+// benchScenarios are the standard scenarios. They're fixed, so that
+// numbers from different machines and versions are comparable.
+var benchScenarios = []benchScenario{
+	{"localize-vga", image.Point{640, 480}},
+	{"localize-1080p", image.Point{1920, 1080}},
+}
+
+// bench runs the standard benchmark scenarios with opts, and prints a
+// report that's comparable across machines and versions.
+//
+// Enrollment and identification scenarios will join these once the
+// pipeline gets that far.
+func bench(opts location.Options) error {
+	if *benchTime <= 0 {
+		return errors.New("bench needs a positive -bench-time")
+	}
+	det := location.NewDetector(&opts)
+	accel, _ := det.Accelerator()
+
+	fmt.Printf("iris bench: %s/%s, %d CPUs, GOMAXPROCS=%d\n", runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.GOMAXPROCS(0))
+	fmt.Printf("fitter %s, accelerator %s, %s per scenario\n\n", opts.Fitter, accel, *benchTime)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "scenario\titerations\tms/op\tops/s\t")
+	for _, sc := range benchScenarios {
+		n, per, err := runScenario(det, sc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sc.Name, err)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%.1f\t\n", sc.Name, n, float64(per)/float64(time.Millisecond), float64(time.Second)/float64(per))
+	}
+	return tw.Flush()
+}
+
+// runScenario localizes the pupil in sc's synthetic image repeatedly
+// for -bench-time, and returns the number of iterations and the mean
+// time per iteration.
+func runScenario(det *location.Detector, sc benchScenario) (int, time.Duration, error) {
+	// The pupil's radius is a tenth of the image height, in the
+	// default radius range for every image size.
+	pupil := location.Circle{
+		Point: image.Point{sc.Size.X / 2, sc.Size.Y / 2},
+		R:     sc.Size.Y / 10,
+	}
+	im := location.SyntheticEye(image.Rectangle{Max: sc.Size}, pupil)
+	defer im.Close()
+
+	ctx := context.Background()
+	// One untimed run, to load models and warm caches.
+	if _, err := det.Locate(ctx, im); err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		n     int
+		start = time.Now()
+	)
+	for time.Since(start) < *benchTime {
+		if _, err := det.Locate(ctx, im); err != nil {
+			return 0, 0, err
+		}
+		n++
+	}
+	return n, time.Since(start) / time.Duration(n), nil
+}
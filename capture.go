@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+	"go.universe.tf/iris/internal/shmframe"
+)
+
+// cameraFormats maps the pixel formats a camera can be asked for to
+// their V4L2 FOURCC codes. NIR iris cameras are often monochrome, or
+// only offer YUYV and MJPEG.
+var cameraFormats = map[string]string{
+	"gray":  "GREY",
+	"yuyv":  "YUYV",
+	"mjpeg": "MJPG",
+}
+
+// fourcc returns the FOURCC code s as a capture property value.
+func fourcc(s string) float64 {
+	return float64(uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24)
+}
+
+// openCamera opens camera device dev. Unless format is "auto", the
+// camera is asked for that pixel format, and frames are delivered
+// raw, without OpenCV's conversion to BGR, for grayFrame to convert.
+func openCamera(dev int, format string) (*gocv.VideoCapture, error) {
+	if format != "auto" && cameraFormats[format] == "" {
+		return nil, fmt.Errorf("unknown camera format %q", format)
+	}
+	vc, err := gocv.VideoCaptureDevice(dev)
+	if err != nil {
+		return nil, err
+	}
+	if format != "auto" {
+		vc.Set(gocv.VideoCaptureFOURCC, fourcc(cameraFormats[format]))
+		vc.Set(gocv.VideoCaptureConvertRGB, 0)
+	}
+	return vc, nil
+}
+
+// grayFrame converts a captured frame to the grayscale the pipeline
+// works on. Only the luma is ever extracted: converting to BGR just
+// to throw the color away would double the per-frame cost.
+//
+// Raw frames are told apart by their shape. Compressed frames come
+// as a single row of bytes, 2 channels are YUYV, and 1 channel is
+// already gray. Converted frames are BGR or BGRA.
+func grayFrame(frame gocv.Mat) (gocv.Mat, error) {
+	ch := frame.Channels()
+	if frame.Rows() == 1 && ch == 1 {
+		// JPEG decoding straight to grayscale skips the chroma
+		// planes entirely.
+		gray, err := gocv.IMDecode(frame.ToBytes(), gocv.IMReadGrayScale)
+		if err == nil && gray.Empty() {
+			gray.Close()
+			err = errors.New("undecodable compressed frame")
+		}
+		return gray, err
+	}
+
+	gray := gocv.NewMat()
+	switch ch {
+	case 1:
+		frame.CopyTo(&gray)
+	case 2:
+		gocv.CvtColor(frame, &gray, gocv.ColorYUVToGRAYYUY2)
+	case 3:
+		gocv.CvtColor(frame, &gray, gocv.ColorBGRToGray)
+	case 4:
+		gocv.CvtColor(frame, &gray, gocv.ColorBGRAToGray)
+	default:
+		gray.Close()
+		return gocv.Mat{}, fmt.Errorf("unsupported frame with %d channels", ch)
+	}
+	return gray, nil
+}
+
+// errNoSource is returned by openSource when no source is given.
+var errNoSource = errors.New("no frame source")
+
+// frameSource is an open source of frames: a video file, a camera,
+// or a shared memory frame ring.
+type frameSource struct {
+	// FPS is the source's nominal frame rate.
+	FPS float64
+	// start starts reading frames in a new goroutine, timestamped
+	// relative to the given time, like decodeFrames.
+	start func(ctx context.Context, t time.Time) <-chan videoFrame
+	close func() error
+}
+
+// Close closes the source. It must only be called once the frames
+// channel returned by start is closed.
+func (s *frameSource) Close() error {
+	return s.close()
+}
+
+// openSource opens whichever of the video file, frame ring and
+// camera is set, using -camera-format for cameras. Exactly one may be
+// set. If none is, it returns errNoSource.
+func openSource(videoPath, shmPath string, cam int) (*frameSource, error) {
+	switch n := countSet(videoPath != "", shmPath != "", cam >= 0); {
+	case n == 0:
+		return nil, errNoSource
+	case n > 1:
+		return nil, errors.New("only one of a video, frame ring or camera can be read per eye")
+	}
+
+	if shmPath != "" {
+		r, err := shmframe.Open(shmPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", shmPath, err)
+		}
+		if r.FPS <= 0 {
+			r.Close()
+			return nil, errs.New(errs.ErrUnsupportedFormat, "frame ring %s doesn't declare a frame rate", shmPath)
+		}
+		return &frameSource{
+			FPS: r.FPS,
+			start: func(ctx context.Context, t time.Time) <-chan videoFrame {
+				return readRing(ctx, r, t)
+			},
+			close: r.Close,
+		}, nil
+	}
+
+	var (
+		vc   *gocv.VideoCapture
+		name = videoPath
+		err  error
+	)
+	if cam >= 0 {
+		name = fmt.Sprintf("camera %d", cam)
+		vc, err = openCamera(cam, *cameraFormat)
+	} else {
+		vc, err = gocv.VideoCaptureFile(videoPath)
+	}
+	if err != nil {
+		return nil, errs.New(errs.ErrUnsupportedFormat, "opening %s: %w", name, err)
+	}
+	fps := vc.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		vc.Close()
+		return nil, errs.New(errs.ErrUnsupportedFormat, "can't determine the frame rate of %s", name)
+	}
+	return &frameSource{
+		FPS: fps,
+		start: func(ctx context.Context, t time.Time) <-chan videoFrame {
+			// Video files play back at their nominal frame rate.
+			// Cameras deliver frames when they get captured,
+			// give or take the driver's buffering.
+			stamp := func(i int) float64 { return float64(i) / fps }
+			if cam >= 0 {
+				stamp = func(int) float64 { return time.Since(t).Seconds() }
+			}
+			return decodeFrames(ctx, vc, stamp)
+		},
+		close: vc.Close,
+	}, nil
+}
+
+// countSet returns how many of bs are true.
+func countSet(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,134 @@
+// Command libiris exposes iris localization through a small C ABI,
+// so that C, C++, Python (via ctypes), Rust and friends can embed the
+// pipeline without running a separate process.
+//
+// Build it with:
+//
+//	go build -buildmode=c-shared -o libiris.so ./cmd/libiris
+//
+// which produces libiris.so and a matching libiris.h. Only the
+// IrisCircle type and the functions marked "//export" below are part
+// of the ABI, and they only ever grow: existing signatures and struct
+// layouts don't change.
+package main
+
+/*
+#include <stdint.h>
+
+// IrisCircle is a circle in image coordinates: x is the column, y
+// the row, both counted from the top-left corner of the image.
+typedef struct {
+	int32_t x;
+	int32_t y;
+	int32_t r;
+} IrisCircle;
+
+// Functions return IRIS_OK on success, including when they looked for
+// something and found nothing, which they report as an IrisCircle with
+// r == 0.
+#define IRIS_OK 0
+#define IRIS_ERR_INVALID_ARGUMENT 1
+*/
+import "C"
+
+import (
+	"math"
+	"runtime"
+	"unsafe"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/location"
+)
+
+// IrisFindPupil locates the pupil in an 8-bit grayscale image of the
+// given size. stride is the distance in bytes between the start of
+// consecutive rows, which must be at least cols. The last row needn't
+// be padded. On success, writes the pupil to *out and returns IRIS_OK.
+// If there is no pupil in the image, out->r is 0.
+//
+//export IrisFindPupil
+func IrisFindPupil(pixels *C.uint8_t, rows, cols, stride C.int32_t, out *C.IrisCircle) C.int {
+	if out == nil {
+		return C.IRIS_ERR_INVALID_ARGUMENT
+	}
+	im, ok := newImage(pixels, rows, cols, stride)
+	if !ok {
+		return C.IRIS_ERR_INVALID_ARGUMENT
+	}
+	defer im.Close()
+
+	setCircle(out, location.FindPupil(im, nil))
+	return C.IRIS_OK
+}
+
+// IrisFitCircle finds the best defined circle in an 8-bit edge map or
+// mask of the given size, laid out as for IrisFindPupil. Nonzero
+// pixels are either on the circle's boundary, or inside it. This is
+// the circle fitting of IrisFindPupil without its edge detection, for
+// callers that have their own. On success, writes the circle to *out
+// and returns IRIS_OK. If there is no circle, out->r is 0.
+//
+//export IrisFitCircle
+func IrisFitCircle(pixels *C.uint8_t, rows, cols, stride C.int32_t, out *C.IrisCircle) C.int {
+	if out == nil {
+		return C.IRIS_ERR_INVALID_ARGUMENT
+	}
+	im, ok := newImage(pixels, rows, cols, stride)
+	if !ok {
+		return C.IRIS_ERR_INVALID_ARGUMENT
+	}
+	defer im.Close()
+
+	// The only failure is finding nothing.
+	c, _, err := location.FitCircle(im, nil)
+	if err != nil {
+		c = location.Circle{}
+	}
+	setCircle(out, c)
+	return C.IRIS_OK
+}
+
+// newImage copies a caller's 8-bit image into a Mat. ok is false if
+// the arguments don't describe a valid image.
+func newImage(pixels *C.uint8_t, rows, cols, stride C.int32_t) (im gocv.Mat, ok bool) {
+	if pixels == nil || rows <= 0 || cols <= 0 || stride < cols {
+		return gocv.Mat{}, false
+	}
+
+	// Copy the pixels into Go memory, dropping any row padding so
+	// that OpenCV sees a contiguous image. The size is computed in
+	// 64 bits, since it can overflow 32 bits well before any of the
+	// dimensions does.
+	n := int64(rows-1)*int64(stride) + int64(cols)
+	if n > math.MaxInt32 {
+		return gocv.Mat{}, false
+	}
+	src := C.GoBytes(unsafe.Pointer(pixels), C.int(n))
+	buf := make([]byte, 0, int(rows)*int(cols))
+	for row := 0; row < int(rows); row++ {
+		start := row * int(stride)
+		buf = append(buf, src[start:start+int(cols)]...)
+	}
+
+	// The Mat only wraps buf, so give the caller its own copy, which
+	// doesn't depend on buf staying alive.
+	wrapped, err := gocv.NewMatFromBytes(int(rows), int(cols), gocv.MatTypeCV8U, buf)
+	if err != nil {
+		return gocv.Mat{}, false
+	}
+	im = wrapped.Clone()
+	wrapped.Close()
+	runtime.KeepAlive(buf)
+	return im, true
+}
+
+// setCircle writes c to out.
+func setCircle(out *C.IrisCircle, c location.Circle) {
+	out.x = C.int32_t(c.X)
+	out.y = C.int32_t(c.Y)
+	out.r = C.int32_t(c.R)
+}
+
+// main is required by -buildmode=c-shared, but never runs.
+func main() {}
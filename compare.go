@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/location"
+)
+
+var (
+	compareA       = flag.String("compare-a", "", "compare: flags file for the first configuration")
+	compareB       = flag.String("compare-b", "", "compare: flags file for the second configuration")
+	compareGallery = flag.String("compare-gallery", "", "compare: directory to write the most disputed images to")
+	compareWorst   = flag.Int("compare-worst", 10, "compare: how many of the most disputed images to put in the gallery")
+)
+
+// A comparison is how two configurations did on one labeled image.
+type comparison struct {
+	Label *labeledImage
+	A, B  location.Circle
+	// Disagreement is how far apart A and B are, in labeled radii.
+	// It's infinite if only one of them found a pupil.
+	Disagreement float64
+}
+
+// compare localizes the labeled images in the CSV file named on the
+// command line (in the format of -tune-labels) with the configurations
+// in -compare-a and -compare-b, and reports how they differ, per image
+// and overall. The images they disagree on most are drawn with both
+// results to -compare-gallery, if set.
+//
+// Configuration files hold command line flags, one per line, like
+// those written by tune -tune-out. They apply on top of the command
+// line.
+func compare(opts location.Options) error {
+	if flag.Arg(0) == "" || *compareA == "" || *compareB == "" {
+		return errors.New("compare needs a labels file, -compare-a and -compare-b")
+	}
+	optsA, err := optionsFromFile(*compareA)
+	if err != nil {
+		return err
+	}
+	optsB, err := optionsFromFile(*compareB)
+	if err != nil {
+		return err
+	}
+	set, err := readLabels(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, l := range set {
+			l.Im.Close()
+		}
+	}()
+
+	foundA, err := localizeSet(optsA, set)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *compareA, err)
+	}
+	foundB, err := localizeSet(optsB, set)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *compareB, err)
+	}
+
+	var (
+		cmps       []comparison
+		resA, resB = tuneResult{Opts: optsA}, tuneResult{Opts: optsB}
+		sumA, sumB float64
+		tw         = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	)
+	fmt.Fprintln(tw, "image\tlabel\ta\terror\tb\terror\tdisagreement")
+	for i := range set {
+		c := comparison{Label: &set[i], A: foundA[i], B: foundB[i]}
+		c.Disagreement = disagreement(c.A, c.B, set[i].Pupil.R)
+		cmps = append(cmps, c)
+
+		errA, okA := labelError(c.A, set[i].Pupil)
+		errB, okB := labelError(c.B, set[i].Pupil)
+		if okA {
+			resA.Found++
+			sumA += errA
+		}
+		if okB {
+			resB.Found++
+			sumB += errB
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.3f\n", set[i].Path, set[i].Pupil, c.A, formatLabelError(errA, okA), c.B, formatLabelError(errB, okB), c.Disagreement)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if resA.Found > 0 {
+		resA.MeanError = sumA / float64(resA.Found)
+	}
+	if resB.Found > 0 {
+		resB.MeanError = sumB / float64(resB.Found)
+	}
+
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "config\tpipeline\tfound\tmean error")
+	fmt.Fprintf(tw, "a %s\t%s\t%d/%d\t%.3f\n", *compareA, optsA.Fingerprint(), resA.Found, len(set), resA.MeanError)
+	fmt.Fprintf(tw, "b %s\t%s\t%d/%d\t%.3f\n", *compareB, optsB.Fingerprint(), resB.Found, len(set), resB.MeanError)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if *compareGallery == "" {
+		return nil
+	}
+	sort.SliceStable(cmps, func(i, j int) bool { return cmps[i].Disagreement > cmps[j].Disagreement })
+	return writeGallery(*compareGallery, cmps)
+}
+
+// formatLabelError formats a result of labelError for a report.
+func formatLabelError(e float64, ok bool) string {
+	if !ok {
+		return "miss"
+	}
+	return fmt.Sprintf("%.3f", e)
+}
+
+// disagreement returns how far apart a and b are, in radii of r.
+func disagreement(a, b location.Circle, r int) float64 {
+	switch {
+	case a.R == 0 && b.R == 0:
+		return 0
+	case a.R == 0 || b.R == 0:
+		return math.Inf(1)
+	}
+	d := a.Point.Sub(b.Point)
+	return (math.Hypot(float64(d.X), float64(d.Y)) + math.Abs(float64(a.R-b.R))) / float64(r)
+}
+
+// writeGallery draws the first -compare-worst of cmps that disagree
+// at all, with the label in blue, configuration a in green and b in
+// red, and writes them to dir, ranked.
+func writeGallery(dir string, cmps []comparison) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, c := range cmps {
+		if i == *compareWorst || c.Disagreement == 0 {
+			break
+		}
+		im := gocv.NewMat()
+		gocv.CvtColor(c.Label.Im, &im, gocv.ColorGrayToBGR)
+		gocv.Circle(&im, c.Label.Pupil.Point, c.Label.Pupil.R, color.RGBA{0, 0, 255, 0}, 1)
+		if c.A.R > 0 {
+			gocv.Circle(&im, c.A.Point, c.A.R, color.RGBA{0, 255, 0, 0}, 1)
+		}
+		if c.B.R > 0 {
+			gocv.Circle(&im, c.B.Point, c.B.R, color.RGBA{255, 0, 0, 0}, 1)
+		}
+		name := fmt.Sprintf("%02d-%s.png", i+1, strings.TrimSuffix(filepath.Base(c.Label.Path), filepath.Ext(c.Label.Path)))
+		ok := gocv.IMWrite(filepath.Join(dir, name), im)
+		im.Close()
+		if !ok {
+			return fmt.Errorf("writing %s failed", filepath.Join(dir, name))
+		}
+	}
+	return nil
+}
+
+// optionsFromFile returns the localization options selected by the
+// command line, overridden by the flags in the file at path. The file
+// has one flag per line, as -name=value or just -name for booleans.
+// Blank lines and lines starting with # are ignored.
+func optionsFromFile(path string) (location.Options, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return location.Options{}, err
+	}
+
+	// Flags live in globals, so apply the file's on top of the
+	// command line's, and put those back afterwards.
+	saved := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		saved[f.Name] = f.Value.String()
+	})
+	defer func() {
+		for name, val := range saved {
+			flag.Set(name, val)
+		}
+	}()
+
+	for n, line := range strings.Split(string(bs), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.TrimLeft(line, "-")
+		val := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, val = name[:i], name[i+1:]
+		}
+		if err := flag.Set(name, val); err != nil {
+			return location.Options{}, fmt.Errorf("%s:%d: %w", path, n+1, err)
+		}
+	}
+	return optionsFromFlags()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+	"go.universe.tf/iris/internal/location"
+)
+
+// fit fits a circle to the edge map or mask named on the command
+// line, with the same circle search as the default pupil fitter, and
+// prints it. It's for checking the fitting on its own, against edge
+// maps or segmentations made by other tools.
+func fit(opts location.Options) error {
+	if flag.Arg(0) == "" {
+		return errors.New("fit needs an edge map or mask image")
+	}
+	im := gocv.IMRead(flag.Arg(0), gocv.IMReadGrayScale)
+	defer im.Close()
+	if im.Empty() {
+		return errs.New(errs.ErrUnsupportedFormat, "can't read image %q", flag.Arg(0))
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	c, diag, err := location.FitCircleContext(ctx, im, &opts)
+	if err != nil {
+		return fmt.Errorf("fitting circle to %q: %w", flag.Arg(0), err)
+	}
+	fmt.Printf("circle %s, %d votes", c, diag.RefinedVotes)
+	if diag.RefinedVotes == 0 {
+		fmt.Printf(" (refinement failed, approximate only, %d coarse votes)", diag.CoarseVotes)
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package artifact persists expensive results, such as located
+// pupils, so that reruns over the same images can skip the stages
+// whose configuration didn't change.
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gocv.io/x/gocv"
+)
+
+// Store is a content-addressed directory of artifacts. An artifact is
+// named by the hash of the input image, the configuration of the
+// stage that produced it, and the stage itself, so a changed input or
+// configuration can never return a stale artifact.
+type Store struct {
+	// Dir is the store directory. It is created if needed.
+	Dir string
+}
+
+// Key returns the key of the artifact produced by stage from the
+// image with hash imageHash, with the stage configured as described
+// by configHash (e.g. location.Options.Fingerprint).
+func Key(stage, imageHash, configHash string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "stage=%s\nimage=%s\nconfig=%s\n", stage, imageHash, configHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashMat returns the hex-encoded SHA-256 of m's size, type and
+// pixels. Unlike a file hash, it's the same whichever format the
+// image was loaded from.
+func HashMat(m gocv.Mat) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%dx%d:%d\n", m.Cols(), m.Rows(), m.Type())
+	h.Write(m.ToBytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// path returns the file that holds the artifact with the given key.
+// Artifacts are spread over subdirectories by their key's first
+// byte, so that no directory gets huge.
+func (s *Store) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key[2:])
+}
+
+// Get returns the artifact with the given key. ok is false if there
+// is none.
+func (s *Store) Get(key string) (data []byte, ok bool, err error) {
+	data, err = ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Put stores data as the artifact with the given key. Readers never
+// see a partial artifact, even if Put is interrupted.
+func (s *Store) Put(key string, data []byte) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// GetJSON decodes the artifact with the given key into v. ok is false
+// if there is none.
+func (s *Store) GetJSON(key string, v interface{}) (ok bool, err error) {
+	data, ok, err := s.Get(key)
+	if !ok || err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("decoding artifact %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// PutJSON stores v, encoded as JSON, as the artifact with the given
+// key.
+func (s *Store) PutJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(key, data)
+}
@@ -0,0 +1,77 @@
+package artifact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	k := Key("pupil", "img", "cfg")
+	if len(k) != 64 {
+		t.Errorf("key %q isn't a hex SHA-256", k)
+	}
+	if Key("pupil", "img", "cfg") != k {
+		t.Error("Key isn't deterministic")
+	}
+	for _, other := range []string{
+		Key("edges", "img", "cfg"),
+		Key("pupil", "img2", "cfg"),
+		Key("pupil", "img", "cfg2"),
+	} {
+		if other == k {
+			t.Errorf("Key collides for different inputs: %s", k)
+		}
+	}
+}
+
+func TestStoreGetPut(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	key := Key("pupil", "img", "cfg")
+
+	if _, ok, err := s.Get(key); ok || err != nil {
+		t.Fatalf("Get of missing artifact = %v, %v, want false, nil", ok, err)
+	}
+	if err := s.Put(key, []byte("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	data, ok, err := s.Get(key)
+	if !ok || err != nil || string(data) != "hello" {
+		t.Fatalf("Get = %q, %v, %v, want \"hello\", true, nil", data, ok, err)
+	}
+
+	// Overwriting replaces the artifact.
+	if err := s.Put(key, []byte("bye")); err != nil {
+		t.Fatalf("second Put: %v", err)
+	}
+	if data, _, _ := s.Get(key); string(data) != "bye" {
+		t.Errorf("Get after overwrite = %q, want \"bye\"", data)
+	}
+}
+
+func TestStoreJSON(t *testing.T) {
+	type circle struct{ X, Y, R int }
+	s := &Store{Dir: t.TempDir()}
+	key := Key("pupil", "img", "cfg")
+
+	var got circle
+	if ok, err := s.GetJSON(key, &got); ok || err != nil {
+		t.Fatalf("GetJSON of missing artifact = %v, %v, want false, nil", ok, err)
+	}
+	want := circle{320, 240, 42}
+	if err := s.PutJSON(key, want); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if ok, err := s.GetJSON(key, &got); !ok || err != nil {
+		t.Fatalf("GetJSON = %v, %v, want true, nil", ok, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetJSON = %+v, want %+v", got, want)
+	}
+
+	if err := s.Put(key, []byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetJSON(key, &got); err == nil {
+		t.Error("GetJSON of a corrupt artifact succeeded")
+	}
+}
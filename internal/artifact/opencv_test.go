@@ -0,0 +1,40 @@
+//go:build opencv
+// +build opencv
+
+package artifact
+
+import (
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+func TestHashMat(t *testing.T) {
+	gradient := func(rows, cols int) gocv.Mat {
+		m := gocv.NewMatWithSize(rows, cols, gocv.MatTypeCV8U)
+		for y := 0; y < rows; y++ {
+			for x := 0; x < cols; x++ {
+				m.SetUCharAt(y, x, uint8(x*y))
+			}
+		}
+		return m
+	}
+	a, b := gradient(30, 40), gradient(30, 40)
+	defer a.Close()
+	defer b.Close()
+	if HashMat(a) != HashMat(b) {
+		t.Errorf("identical images hash differently")
+	}
+
+	b.SetUCharAt(10, 10, 0)
+	if HashMat(a) == HashMat(b) {
+		t.Errorf("images differing by a pixel hash the same")
+	}
+
+	// Same pixels, arranged differently.
+	flat := a.Reshape(1, 40)
+	defer flat.Close()
+	if HashMat(flat) == HashMat(a) {
+		t.Errorf("images of different shapes hash the same")
+	}
+}
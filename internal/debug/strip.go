@@ -0,0 +1,72 @@
+package debug
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// stripWidth and stripHeight are the size of the unwrapped iris
+	// strip: one column per angular step, one row per radial step.
+	stripWidth  = 512
+	stripHeight = 64
+)
+
+// IrisStrip unwraps the annulus of im between radii inner and outer
+// around center into a rectangular strip, Daugman rubber-sheet style,
+// and returns it as a color image for display.
+//
+// Angles increase left to right starting from the angular origin,
+// which points right (towards +X) from center, and is marked with a
+// green line at the strip's left edge. Radius increases top to
+// bottom, from the pupil boundary to the limbus. If noise isn't
+// empty, it's an 8-bit mask the same size as im, and its non-zero
+// pixels are tinted red in the strip.
+func IrisStrip(im gocv.Mat, center image.Point, inner, outer int, noise gocv.Mat) gocv.Mat {
+	gray := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), stripHeight, stripWidth, gocv.MatTypeCV8U)
+	defer gray.Close()
+	mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), stripHeight, stripWidth, gocv.MatTypeCV8U)
+	defer mask.Close()
+
+	b := image.Rect(0, 0, im.Cols(), im.Rows())
+	for col := 0; col < stripWidth; col++ {
+		angle := 2 * math.Pi * float64(col) / stripWidth
+		dx, dy := math.Cos(angle), math.Sin(angle)
+		for row := 0; row < stripHeight; row++ {
+			r := float64(inner) + float64(outer-inner)*float64(row)/(stripHeight-1)
+			p := center.Add(image.Point{
+				X: int(math.Round(r * dx)),
+				Y: int(math.Round(r * dy)),
+			})
+			if !p.In(b) {
+				// Off the edge of the image is as good as
+				// occluded.
+				mask.SetUCharAt(row, col, 255)
+				continue
+			}
+			// gocv wants (row, col), image.Point is (x, y).
+			gray.SetUCharAt(row, col, im.GetUCharAt(p.Y, p.X))
+			if !noise.Empty() && noise.GetUCharAt(p.Y, p.X) != 0 {
+				mask.SetUCharAt(row, col, 255)
+			}
+		}
+	}
+
+	ret := gocv.NewMat()
+	gocv.CvtColor(gray, &ret, gocv.ColorGrayToBGR)
+
+	// Blend masked pixels with red, rather than painting over them,
+	// so the texture underneath stays visible.
+	red := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 255, 0), stripHeight, stripWidth, gocv.MatTypeCV8UC3)
+	defer red.Close()
+	tinted := gocv.NewMat()
+	defer tinted.Close()
+	gocv.AddWeighted(ret, 0.5, red, 0.5, 0, &tinted)
+	tinted.CopyToWithMask(&ret, mask)
+
+	gocv.Line(&ret, image.Point{0, 0}, image.Point{0, stripHeight - 1}, color.RGBA{0, 255, 0, 255}, 2)
+	return ret
+}
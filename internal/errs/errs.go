@@ -0,0 +1,74 @@
+// Package errs defines the kinds of failure that the iris pipeline
+// reports, so that callers can react to why something failed rather
+// than parse error messages.
+//
+// Errors of a kind match it with errors.Is, however deeply they are
+// wrapped, as long as every layer wraps with %w.
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound means the feature being looked for, such as the
+	// pupil or the iris, isn't in the image.
+	ErrNotFound = errors.New("not found")
+	// ErrLowQuality means the input is too poor to get a trustworthy
+	// result from, e.g. too blurry or too dark.
+	ErrLowQuality = errors.New("quality too low")
+	// ErrUnsupportedFormat means an input file, such as an image,
+	// video or model, couldn't be read or isn't what was expected.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrBudgetExceeded means the work ran out of time.
+	ErrBudgetExceeded = errors.New("time budget exceeded")
+)
+
+// kindError is an error of a particular kind. Its message is that of
+// the error it wraps, the kind is only for matching.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string        { return e.err.Error() }
+func (e *kindError) Unwrap() error        { return e.err }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// New returns an error of the given kind, formatted as by fmt.Errorf.
+func New(kind error, format string, args ...interface{}) error {
+	return &kindError{kind, fmt.Errorf(format, args...)}
+}
+
+// FromContext returns err, a context's error, as ErrBudgetExceeded if
+// the context's deadline passed. Other errors, including
+// cancellation, are returned as is.
+func FromContext(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &kindError{ErrBudgetExceeded, err}
+	}
+	return err
+}
+
+// ExitCode returns the process exit code for a command that failed
+// with err: 0 for nil, a distinct code for each kind, and 1 for
+// anything else. Code 2 is left to the flag package, for usage
+// errors.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrNotFound):
+		return 3
+	case errors.Is(err, ErrLowQuality):
+		return 4
+	case errors.Is(err, ErrUnsupportedFormat):
+		return 5
+	case errors.Is(err, ErrBudgetExceeded):
+		return 6
+	default:
+		return 1
+	}
+}
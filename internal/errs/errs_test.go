@@ -0,0 +1,47 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("boom"), 1},
+		{ErrNotFound, 3},
+		{New(ErrNotFound, "no pupil in %s", "eye.png"), 3},
+		{fmt.Errorf("frame 12: %w", New(ErrNotFound, "no pupil")), 3},
+		{New(ErrLowQuality, "too blurry"), 4},
+		{New(ErrUnsupportedFormat, "bad image"), 5},
+		{FromContext(context.DeadlineExceeded), 6},
+		{fmt.Errorf("localizing: %w", FromContext(context.DeadlineExceeded)), 6},
+		{FromContext(context.Canceled), 1},
+		// Wrapping with %v loses the kind.
+		{fmt.Errorf("frame 12: %v", New(ErrNotFound, "no pupil")), 1},
+	}
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestNewKeepsMessageAndCause(t *testing.T) {
+	cause := errors.New("truncated file")
+	err := New(ErrUnsupportedFormat, "reading eye.png: %w", cause)
+	if got, want := err.Error(), "reading eye.png: truncated file"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("errors.Is(err, cause) = false, want true")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("errors.Is(err, ErrNotFound) = true, want false")
+	}
+}
@@ -0,0 +1,21 @@
+package houghcircle
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// As in the location package, all geometry here is done with
+// image.Point, and only the pixel package and the helpers below talk
+// to gocv in (row, col) terms.
+
+// shortAt returns the value of the 16-bit integer pixel at p in m.
+func shortAt(m gocv.Mat, p image.Point) int16 {
+	return m.GetShortAt(p.Y, p.X)
+}
+
+// setShortAt sets the 16-bit integer pixel at p in m to v.
+func setShortAt(m *gocv.Mat, p image.Point, v int16) {
+	m.SetShortAt(p.Y, p.X, v)
+}
@@ -0,0 +1,280 @@
+// Package houghcircle finds circles and circular arcs in edge maps,
+// with a circular Hough transform and an exhaustive local refinement.
+//
+// Edge maps are 8-bit single channel images. Zero pixels are ignored,
+// any other value is a candidate point on the circles being looked
+// for.
+package houghcircle
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"runtime"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+// Candidate is a circle found by Hough voting.
+type Candidate struct {
+	// Point is the circle's center.
+	image.Point
+	// R is the circle's radius.
+	R int
+	// Votes is the number of edge pixels that lie on the circle.
+	Votes int
+	// Score is Votes, adjusted by any weighting applied during the
+	// search. Candidates should be compared by Score.
+	Score float64
+}
+
+func (c Candidate) String() string {
+	return fmt.Sprintf("(%d,%d,%d)[%d votes]", c.X, c.Y, c.R, c.Votes)
+}
+
+// Options tunes a search. The zero value searches the full circle,
+// over the whole image, on every CPU.
+type Options struct {
+	// Arcs restricts the search to edge pixels on these arcs of the
+	// circle, relative to its center. This finds circles that are
+	// partly hidden, e.g. the limbus behind the eyelids. Empty means
+	// the full circle.
+	Arcs []Arc
+	// ROI restricts the search to circles centered inside it. The
+	// circles themselves can extend past it. Empty means the whole
+	// image.
+	ROI image.Rectangle
+	// Weight, if non-nil, must be a CV_32F matrix the same size as
+	// the edge map. Each accumulator cell's votes get multiplied by
+	// the matching weight before picking the strongest candidate,
+	// which lets callers favor some centers over others. Refine
+	// ignores it.
+	Weight *gocv.Mat
+	// NoSmoothing disables lightly blurring the accumulator before
+	// looking for peaks.
+	NoSmoothing bool
+	// Workers is the number of goroutines to spread radii over. Zero
+	// means one per CPU.
+	Workers int
+	// Table holds precomputed circle rasterizations. It is only used
+	// when searching full circles.
+	Table Table
+}
+
+// workers returns the number of goroutines to use for n radii.
+func (o *Options) workers(n int) int {
+	w := o.Workers
+	if w <= 0 {
+		w = runtime.NumCPU()
+	}
+	if w > n {
+		w = n
+	}
+	return w
+}
+
+// roi returns the region that circle centers must be in, within b.
+func (o *Options) roi(b image.Rectangle) image.Rectangle {
+	if o.ROI.Empty() {
+		return b
+	}
+	return o.ROI.Intersect(b)
+}
+
+// Vote runs a circular Hough transform on im, for each of the given
+// radii, and returns the strongest candidate circle for each radius.
+// opts may be nil, in which case defaults are used.
+//
+// If ctx is done, Vote stops early and returns the candidates for the
+// radii it got through.
+func Vote(ctx context.Context, im gocv.Mat, radii []int, opts *Options) []Candidate {
+	if opts == nil {
+		opts = &Options{}
+	}
+	b := pixel.Bounds(im)
+	ret := make([]Candidate, 0, len(radii))
+
+	// Nothing to vote with, don't bother.
+	if gocv.CountNonZero(im) == 0 || opts.roi(b).Empty() {
+		for _, r := range radii {
+			ret = append(ret, Candidate{R: r})
+		}
+		return ret
+	}
+
+	// Each worker gets its own accumulator and scratch space, reused
+	// for all the radii it handles.
+	//
+	// The circle Hough transform uses a "voting matrix". We make a
+	// variety of guesses as to where the circle center might be,
+	// and this matrix tracks the number of "votes" that each pixel
+	// gets for being the center.
+	//
+	// The matrix is 16-bit, which is plenty: a cell gets at most one
+	// vote per offset in the circle's rasterization, and Points
+	// returns at most 360 of those.
+	//
+	// A fresh matrix is uninitialized memory, so it gets cleared
+	// before each radius anyway, and reusing it saves an allocation
+	// per radius.
+	workers := opts.workers(len(radii))
+	votes := make([]gocv.Mat, workers)
+	scores := make([]gocv.Mat, workers)
+	bufs := make([]*[]image.Point, workers)
+	for w := range votes {
+		votes[w] = gocv.NewMatWithSize(b.Dy(), b.Dx(), gocv.MatTypeCV16S)
+		defer votes[w].Close()
+		scores[w] = gocv.NewMat()
+		defer scores[w].Close()
+		bufs[w] = pointBufs.Get().(*[]image.Point)
+		defer pointBufs.Put(bufs[w])
+	}
+
+	cands := make([]Candidate, len(radii))
+	done := make([]bool, len(radii))
+	forEach(ctx, len(radii), workers, func(w, i int) {
+		pts := pointsFor(opts.Table, radii[i], opts.Arcs, bufs[w])
+		cands[i] = voteRadius(im, radii[i], pts, &votes[w], &scores[w], opts)
+		done[i] = true
+	})
+
+	for i, c := range cands {
+		if done[i] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// voteRadius runs the Hough transform for circles of radius r, whose
+// rasterization is circlePoints, and returns the strongest one. votes
+// and scores are scratch space, votes must be CV16S and the same size
+// as im.
+func voteRadius(im gocv.Mat, r int, circlePoints []image.Point, votes, scores *gocv.Mat, opts *Options) Candidate {
+	b := pixel.Bounds(im)
+	roi := opts.roi(b)
+	votes.SetTo(gocv.NewScalar(0, 0, 0, 0))
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			p := image.Point{x, y}
+
+			// Skip black pixels.
+			if pixel.UCharAt(im, p) == 0 {
+				continue
+			}
+
+			// We think this pixel might be on our circle. If true,
+			// its center would be somewhere on a circle of radius r
+			// and centered here. Add a vote to each of those
+			// locations in the voting matrix. With arcs, only the
+			// centers that put p on one of them qualify, so the
+			// offsets go the other way.
+			for _, cp := range circlePoints {
+				// c is our candidate centerpoint.
+				c := p.Sub(cp)
+				if !c.In(roi) {
+					continue
+				}
+
+				// One vote for c as the center.
+				setShortAt(votes, c, shortAt(*votes, c)+1)
+			}
+		}
+	}
+
+	// The voting matrix is now complete. Time to count, and see who
+	// won. OpenCV can find the peak far faster than we can by
+	// poking at every cell through cgo.
+	votes.ConvertTo(scores, gocv.MatTypeCV32F)
+	if !opts.NoSmoothing {
+		// A true circle center rarely lands exactly on one
+		// accumulator cell, so its votes get split between
+		// neighbors, while noise tends to produce isolated
+		// single-cell spikes. A 3x3 box blur rewards the former
+		// over the latter.
+		gocv.Blur(*scores, scores, image.Point{3, 3})
+	}
+	if opts.Weight != nil {
+		gocv.Multiply(*scores, *opts.Weight, scores)
+	}
+	_, score, _, loc := gocv.MinMaxLoc(*scores)
+	best := Candidate{R: r}
+	if score > 0 {
+		best.Point = loc
+		best.Votes = int(shortAt(*votes, loc))
+		best.Score = float64(score)
+	}
+	return best
+}
+
+// Refine searches exhaustively for the best circle in im within
+// window pixels of c, on center and radius. opts may be nil, in
+// which case defaults are used.
+//
+// If ctx is done, Refine stops early and returns the best circle
+// found so far.
+func Refine(ctx context.Context, im gocv.Mat, c Candidate, window int, opts *Options) Candidate {
+	if opts == nil {
+		opts = &Options{}
+	}
+	b := pixel.Bounds(im)
+	roi := opts.roi(b)
+
+	// We know a cube of (window, window, window) for where the
+	// circle (x, y, r) is. That's a pretty small grid even on a
+	// very large image, so we can just search it exhaustively, and
+	// pick the position that results in the most non-zero pixels on
+	// the resulting circle.
+	var radii []int
+	for r := c.R - window; r <= c.R+window; r++ {
+		if r >= 1 {
+			radii = append(radii, r)
+		}
+	}
+	workers := opts.workers(len(radii))
+	bufs := make([]*[]image.Point, workers)
+	for w := range bufs {
+		bufs[w] = pointBufs.Get().(*[]image.Point)
+		defer pointBufs.Put(bufs[w])
+	}
+
+	best := make([]Candidate, len(radii))
+	forEach(ctx, len(radii), workers, func(w, i int) {
+		r := radii[i]
+		circlePoints := pointsFor(opts.Table, r, opts.Arcs, bufs[w])
+		for y := c.Y - window; y <= c.Y+window; y++ {
+			for x := c.X - window; x <= c.X+window; x++ {
+				center := image.Point{x, y}
+				if !center.In(roi) {
+					continue
+				}
+				votes := 0
+				for _, cp := range circlePoints {
+					p := center.Add(cp)
+					if p.In(b) && pixel.UCharAt(im, p) != 0 {
+						votes++
+					}
+				}
+				if votes > best[i].Votes {
+					best[i].Point = center
+					best[i].R = r
+					best[i].Votes = votes
+					best[i].Score = float64(votes)
+				}
+			}
+		}
+	})
+
+	// Ties go to the smallest radius, as they would searching
+	// serially.
+	var winner Candidate
+	for _, c := range best {
+		if c.Votes > winner.Votes {
+			winner = c
+		}
+	}
+	return winner
+}
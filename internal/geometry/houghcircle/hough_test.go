@@ -0,0 +1,182 @@
+//go:build opencv
+// +build opencv
+
+package houghcircle
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// edgeMap returns a black w x h edge map with the given circles drawn
+// on it, one pixel wide.
+func edgeMap(w, h int, circles ...Candidate) gocv.Mat {
+	im := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), h, w, gocv.MatTypeCV8U)
+	for _, c := range circles {
+		gocv.Circle(&im, c.Point, c.R, color.RGBA{255, 255, 255, 255}, 1)
+	}
+	return im
+}
+
+// strongest returns the candidate with the highest score.
+func strongest(cands []Candidate) Candidate {
+	var ret Candidate
+	for _, c := range cands {
+		if c.Score > ret.Score {
+			ret = c
+		}
+	}
+	return ret
+}
+
+func TestVote(t *testing.T) {
+	want := Candidate{Point: image.Point{70, 45}, R: 20}
+	im := edgeMap(160, 100, want)
+	defer im.Close()
+
+	radii := []int{15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25}
+	cands := Vote(context.Background(), im, radii, &Options{NoSmoothing: true})
+	if len(cands) != len(radii) {
+		t.Fatalf("got %d candidates, want one per radius", len(cands))
+	}
+	for i, c := range cands {
+		if c.R != radii[i] {
+			t.Errorf("candidate %d has radius %d, want %d", i, c.R, radii[i])
+		}
+	}
+	got := strongest(cands)
+	if got.Point != want.Point || got.R != want.R {
+		t.Errorf("strongest candidate %s, want %s", got, want)
+	}
+	if got.Votes < len(Points(want.R))/2 {
+		t.Errorf("winner has %d votes, want most of the circle's %d pixels", got.Votes, len(Points(want.R)))
+	}
+}
+
+func TestVoteOptions(t *testing.T) {
+	left := Candidate{Point: image.Point{40, 50}, R: 20}
+	right := Candidate{Point: image.Point{120, 50}, R: 20}
+	im := edgeMap(160, 100, left, right)
+	defer im.Close()
+	ctx := context.Background()
+
+	// ROI picks one of two identical circles.
+	got := strongest(Vote(ctx, im, []int{20}, &Options{ROI: image.Rect(80, 0, 160, 100)}))
+	if got.Point != right.Point {
+		t.Errorf("with ROI on the right, found %s, want %s", got, right)
+	}
+
+	// So does weighting.
+	w := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(1, 0, 0, 0), 100, 160, gocv.MatTypeCV32F)
+	defer w.Close()
+	gocv.Rectangle(&w, image.Rect(0, 0, 80, 100), color.RGBA{2, 0, 0, 0}, -1)
+	got = strongest(Vote(ctx, im, []int{20}, &Options{Weight: &w}))
+	if got.Point != left.Point {
+		t.Errorf("weighted to the left, found %s, want %s", got, left)
+	}
+
+	// Arcs find a circle from part of its boundary. Only the right
+	// half of this one is drawn.
+	half := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), 100, 160, gocv.MatTypeCV8U)
+	defer half.Close()
+	gocv.Circle(&half, left.Point, left.R, color.RGBA{255, 255, 255, 255}, 1)
+	gocv.Rectangle(&half, image.Rect(0, 0, left.X, 100), color.RGBA{0, 0, 0, 0}, -1)
+	got = strongest(Vote(ctx, half, []int{18, 19, 20, 21, 22}, &Options{Arcs: []Arc{{300, 60}}, NoSmoothing: true}))
+	if got.Point != left.Point || got.R != left.R {
+		t.Errorf("on the right arc, found %s, want %s", got, left)
+	}
+}
+
+func TestVoteEmpty(t *testing.T) {
+	im := edgeMap(50, 50)
+	defer im.Close()
+	cands := Vote(context.Background(), im, []int{5, 6}, nil)
+	if len(cands) != 2 {
+		t.Fatalf("got %d candidates, want 2", len(cands))
+	}
+	for _, c := range cands {
+		if c.Votes != 0 || c.Score != 0 {
+			t.Errorf("candidate %s has votes on an empty map", c)
+		}
+	}
+}
+
+func TestVoteCanceled(t *testing.T) {
+	im := edgeMap(100, 100, Candidate{Point: image.Point{50, 50}, R: 20})
+	defer im.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if cands := Vote(ctx, im, []int{18, 19, 20, 21}, &Options{Workers: 1}); len(cands) == 4 {
+		t.Errorf("canceled Vote still voted on every radius")
+	}
+}
+
+func TestRefine(t *testing.T) {
+	want := Candidate{Point: image.Point{211, 137}, R: 63}
+	im := edgeMap(400, 300, want)
+	defer im.Close()
+	ctx := context.Background()
+
+	for _, start := range []Candidate{
+		want,
+		{Point: image.Point{206, 141}, R: 60},
+		{Point: image.Point{216, 132}, R: 68},
+	} {
+		got := Refine(ctx, im, start, 5, nil)
+		if got.Point != want.Point || got.R != want.R {
+			t.Errorf("Refine from %s = %s, want %s", start, got, want)
+		}
+		if got.Votes != int(got.Score) {
+			t.Errorf("Refine from %s: score %v doesn't match %d votes", start, got.Score, got.Votes)
+		}
+	}
+
+	// Out of the window, the true circle can't be found.
+	far := Candidate{Point: image.Point{190, 137}, R: 63}
+	if got := Refine(ctx, im, far, 5, nil); got.Point == want.Point {
+		t.Errorf("Refine from %s found %s, outside its window", far, got)
+	}
+}
+
+func TestRefineROI(t *testing.T) {
+	want := Candidate{Point: image.Point{100, 100}, R: 30}
+	im := edgeMap(200, 200, want)
+	defer im.Close()
+	opts := &Options{ROI: image.Rect(0, 0, 98, 200)}
+	got := Refine(context.Background(), im, want, 4, opts)
+	if got.X >= 98 {
+		t.Errorf("Refine with ROI left of x=98 found %s", got)
+	}
+}
+
+// BenchmarkVote and BenchmarkRefine report allocations per call,
+// which under video load turn into GC churn.
+func BenchmarkVote(b *testing.B) {
+	im := edgeMap(80, 60, Candidate{Point: image.Point{40, 30}, R: 12})
+	defer im.Close()
+	radii := []int{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	opts := &Options{Table: NewTable(5, 15)}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Vote(ctx, im, radii, opts)
+	}
+}
+
+func BenchmarkRefine(b *testing.B) {
+	want := Candidate{Point: image.Point{320, 240}, R: 70}
+	im := edgeMap(640, 480, want)
+	defer im.Close()
+	start := Candidate{Point: image.Point{325, 236}, R: 66}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Refine(ctx, im, start, 9, nil)
+	}
+}
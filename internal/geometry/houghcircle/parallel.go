@@ -0,0 +1,37 @@
+package houghcircle
+
+import (
+	"context"
+	"sync"
+)
+
+// forEach calls fn(worker, i) for each i in [0, n), spread over up to
+// workers goroutines. worker identifies the goroutine making the
+// call, in [0, workers), so that fn can use per-worker scratch space
+// without locking. forEach stops handing out work once ctx is done,
+// and returns once all calls in flight have finished.
+func forEach(ctx context.Context, n, workers int, fn func(worker, i int)) {
+	if workers <= 1 {
+		for i := 0; i < n && ctx.Err() == nil; i++ {
+			fn(0, i)
+		}
+		return
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range work {
+				fn(w, i)
+			}
+		}(w)
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package houghcircle
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// An Arc is a range of angles on a circle, in degrees. Angles are
+// measured from the positive X axis towards the positive Y axis,
+// which is clockwise on screen since image Y points down. The arc
+// runs from From to To in that direction, and may wrap past 360,
+// e.g. {300, 60} is the right-hand third of the circle.
+type Arc struct {
+	From, To float64
+}
+
+// contains reports whether the angle deg, in [0, 360), is on a.
+func (a Arc) contains(deg float64) bool {
+	from, to := math.Mod(a.From, 360), math.Mod(a.To, 360)
+	if from < 0 {
+		from += 360
+	}
+	if to < 0 {
+		to += 360
+	}
+	if from <= to {
+		return deg >= from && deg <= to
+	}
+	return deg >= from || deg <= to
+}
+
+// onArcs reports whether the angle deg is on any of arcs. No arcs
+// means the whole circle.
+func onArcs(arcs []Arc, deg float64) bool {
+	if len(arcs) == 0 {
+		return true
+	}
+	for _, a := range arcs {
+		if a.contains(deg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Points returns the offsets from a circle's center to the pixels on
+// its boundary, for a circle of radius r. If arcs are given, only
+// the pixels on those arcs are included.
+func Points(r int, arcs ...Arc) []image.Point {
+	return appendPoints(nil, r, arcs)
+}
+
+// appendPoints appends the offsets Points would return to dst, and
+// returns the extended slice.
+func appendPoints(dst []image.Point, r int, arcs []Arc) []image.Point {
+	var last image.Point
+	for i := 0; i < 360; i++ {
+		if !onArcs(arcs, float64(i)) {
+			continue
+		}
+		x := int(float64(r) * math.Cos(float64(i)*math.Pi/180.0))
+		y := int(float64(r) * math.Sin(float64(i)*math.Pi/180.0))
+		p := image.Point{x, y}
+		if p != last {
+			dst = append(dst, p)
+			last = p
+		}
+	}
+	return dst
+}
+
+// A Table holds precomputed full circle rasterizations, indexed by
+// radius. Searches look radii up in their Table before computing
+// them, which saves a lot of work when the same radii are searched
+// over and over, e.g. on every frame of a video.
+type Table map[int][]image.Point
+
+// NewTable returns a Table of the circles with radii from minR to
+// maxR, inclusive.
+func NewTable(minR, maxR int) Table {
+	ret := make(Table, maxR-minR+1)
+	for r := minR; r <= maxR; r++ {
+		ret[r] = Points(r)
+	}
+	return ret
+}
+
+// pointBufs recycles the slices that pointsFor rasterizes radii
+// missing from the Table into. Refinement rasterizes several radii
+// per call, and without recycling, each one is garbage a moment
+// later.
+var pointBufs = sync.Pool{
+	New: func() interface{} { return new([]image.Point) },
+}
+
+// pointsFor returns the boundary offsets for a circle of radius r on
+// arcs, from tab if possible. Otherwise, they are computed into
+// *buf, which is reused and possibly grown, so the result is only
+// valid until the next call with the same buf.
+func pointsFor(tab Table, r int, arcs []Arc, buf *[]image.Point) []image.Point {
+	if len(arcs) == 0 {
+		if pts, ok := tab[r]; ok {
+			return pts
+		}
+	}
+	*buf = appendPoints((*buf)[:0], r, arcs)
+	return *buf
+}
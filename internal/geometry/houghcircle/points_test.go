@@ -0,0 +1,102 @@
+package houghcircle
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestPoints(t *testing.T) {
+	for _, r := range []int{5, 20, 73, 200} {
+		pts := Points(r)
+		for i, p := range pts {
+			if d := math.Hypot(float64(p.X), float64(p.Y)); math.Abs(d-float64(r)) > 1.5 {
+				t.Errorf("r=%d: point %v is %.2f from the center", r, p, d)
+			}
+			// The rasterization is a closed curve, without gaps
+			// wider than a pixel, except where 1° steps are
+			// coarser than a pixel on big circles.
+			q := pts[(i+1)%len(pts)]
+			if i+1 < len(pts) && q == p {
+				t.Errorf("r=%d: point %v repeated", r, p)
+			}
+			gap := float64(r) * math.Pi / 180
+			if d := math.Hypot(float64(q.X-p.X), float64(q.Y-p.Y)); d > math.Max(math.Sqrt2, gap+1.5) {
+				t.Errorf("r=%d: gap of %.2f between %v and %v", r, d, p, q)
+			}
+		}
+	}
+}
+
+func TestPointsArcs(t *testing.T) {
+	full := Points(40)
+	right := Points(40, Arc{300, 60})
+	if len(right) == 0 || len(right) >= len(full) {
+		t.Fatalf("right arc has %d points, full circle %d", len(right), len(full))
+	}
+	for _, p := range right {
+		if p.X <= 0 {
+			t.Errorf("point %v isn't on the right-hand arc", p)
+		}
+	}
+	// Arcs on both sides together cover both sides.
+	both := Points(40, Arc{300, 60}, Arc{120, 240})
+	var left int
+	for _, p := range both {
+		if p.X < 0 {
+			left++
+		}
+	}
+	if left == 0 || len(both) <= len(right) {
+		t.Errorf("two arcs gave %d points, %d on the left", len(both), left)
+	}
+}
+
+func TestNewTable(t *testing.T) {
+	tab := NewTable(10, 12)
+	if len(tab) != 3 {
+		t.Errorf("table has %d radii, want 3", len(tab))
+	}
+	for r := 10; r <= 12; r++ {
+		if got, want := len(tab[r]), len(Points(r)); got != want {
+			t.Errorf("tab[%d] has %d points, want %d", r, got, want)
+		}
+	}
+}
+
+func TestPointsForReusesBuffer(t *testing.T) {
+	buf := pointBufs.Get().(*[]image.Point)
+	defer pointBufs.Put(buf)
+	pointsFor(nil, 80, nil, buf)
+	allocs := testing.AllocsPerRun(100, func() {
+		for r := 60; r <= 80; r++ {
+			pointsFor(nil, r, nil, buf)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("pointsFor allocated %v times per run with a warm buffer, want 0", allocs)
+	}
+}
+
+// BenchmarkPoints and BenchmarkPointsFor compare rasterizing the
+// radii of a refinement pass into fresh slices and into a recycled
+// one.
+func BenchmarkPoints(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for r := 60; r <= 80; r++ {
+			Points(r)
+		}
+	}
+}
+
+func BenchmarkPointsFor(b *testing.B) {
+	b.ReportAllocs()
+	buf := pointBufs.Get().(*[]image.Point)
+	defer pointBufs.Put(buf)
+	for i := 0; i < b.N; i++ {
+		for r := 60; r <= 80; r++ {
+			pointsFor(nil, r, nil, buf)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Package pixel translates between image.Point geometry and gocv's
+// pixel accessors.
+//
+// gocv indexes pixels as (row, col), and Mat.Size() is [rows,
+// cols]. image.Point is (X, Y), where X is the column and Y is the
+// row: backwards from gocv. Mixing the two up is easy, and invisible
+// on square images, so the geometry packages do all their geometry
+// with image.Point and image.Rectangle, and only talk to gocv in
+// (row, col) terms through helpers like these.
+package pixel
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// Bounds returns the rectangle covered by m. Its Min is always (0,0).
+func Bounds(m gocv.Mat) image.Rectangle {
+	return image.Rect(0, 0, m.Cols(), m.Rows())
+}
+
+// UCharAt returns the value of the 8-bit pixel at p in m.
+func UCharAt(m gocv.Mat, p image.Point) uint8 {
+	return m.GetUCharAt(p.Y, p.X)
+}
@@ -0,0 +1,41 @@
+package location
+
+import "go.universe.tf/iris/internal/errs"
+
+// AverageIrisDiameter is the average human iris diameter, in
+// millimeters. It varies little between adults, about ±0.5mm, which
+// makes the iris a usable ruler when nothing better is available.
+const AverageIrisDiameter = 11.7
+
+// Calibration relates image pixels to physical distances on the eye.
+// The zero value is uncalibrated.
+type Calibration struct {
+	// PixelsPerMM is the image scale at the eye, in pixels per
+	// millimeter.
+	PixelsPerMM float64
+}
+
+// Calibrated reports whether c can convert to millimeters.
+func (c Calibration) Calibrated() bool {
+	return c.PixelsPerMM > 0
+}
+
+// MM converts px pixels to millimeters. c must be calibrated.
+func (c Calibration) MM(px float64) float64 {
+	return px / c.PixelsPerMM
+}
+
+// Pixels converts mm millimeters to pixels. c must be calibrated.
+func (c Calibration) Pixels(mm float64) float64 {
+	return mm * c.PixelsPerMM
+}
+
+// CalibrateFromIris estimates the image scale by assuming that limbus,
+// the outer iris boundary, is AverageIrisDiameter across. Expect
+// errors of around 5% from individual variation alone.
+func CalibrateFromIris(limbus Circle) (Calibration, error) {
+	if limbus.R <= 0 {
+		return Calibration{}, errs.New(errs.ErrNotFound, "no iris to calibrate from")
+	}
+	return Calibration{PixelsPerMM: float64(2*limbus.R) / AverageIrisDiameter}, nil
+}
@@ -0,0 +1,95 @@
+//go:build opencv
+// +build opencv
+
+package location
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// edgeCircle returns a black w x h edge map with c drawn on it, one
+// pixel wide.
+func edgeCircle(w, h int, c Circle) gocv.Mat {
+	im := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), h, w, gocv.MatTypeCV8U)
+	gocv.Circle(&im, c.Point, c.R, white, 1)
+	return im
+}
+
+func TestRefineWindowContainsCircle(t *testing.T) {
+	// A 480 row image gets shrunk 8x for the coarse pass. The
+	// adversarial circles sit on and around thumbnail pixel
+	// boundaries, with radii halfway between thumbnail radii, where
+	// rounding is most likely to push the approximation out of the
+	// window.
+	const w, h = 640, 480
+	opts := &Options{}
+	var circles []Circle
+	for _, x := range []int{240, 243, 244, 247, 251} {
+		for _, y := range []int{200, 203, 204, 207} {
+			for _, r := range []int{44, 48, 52, 60, 75, 84} {
+				circles = append(circles, Circle{Point: image.Point{x, y}, R: r})
+			}
+		}
+	}
+	for _, want := range circles {
+		im := edgeCircle(w, h, want)
+		approx, mult := coarseCircle(context.Background(), im, nil, opts)
+		im.Close()
+		if mult != 8 {
+			t.Fatalf("thumbnail shrunk by %v, want 8", mult)
+		}
+		win := refineWindow(mult)
+		d := approx.Point.Sub(want.Point)
+		dr := approx.R - want.R
+		if abs(d.X) > win || abs(d.Y) > win || abs(dr) > win {
+			t.Errorf("%s: coarse %s is outside the refinement window of %d", want, approx, win)
+		}
+	}
+}
+
+func TestFindBestCircle(t *testing.T) {
+	want := Circle{Point: image.Point{251, 203}, R: 57}
+	im := edgeCircle(640, 480, want)
+	defer im.Close()
+	got, diag, ok := findBestCircle(context.Background(), im, nil, &Options{})
+	if !ok {
+		t.Fatalf("refinement failed, coarse %s", diag.Coarse)
+	}
+	if got != want {
+		t.Errorf("findBestCircle = %s, want %s", got, want)
+	}
+	if diag.RefinedVotes < diag.CoarseVotes {
+		t.Errorf("refined circle has %d votes, coarse %d", diag.RefinedVotes, diag.CoarseVotes)
+	}
+
+	// With the wrong prior, clear evidence still wins.
+	prior := image.Point{500, 400}
+	if got, _, _ := findBestCircle(context.Background(), im, &prior, &Options{}); got != want {
+		t.Errorf("findBestCircle with a far prior = %s, want %s", got, want)
+	}
+}
+
+func TestFindBestCircleUnshrunk(t *testing.T) {
+	// Images no taller than the thumbnail skip refinement.
+	want := Circle{Point: image.Point{47, 29}, R: 11}
+	im := edgeCircle(90, 60, want)
+	defer im.Close()
+	got, diag, ok := findBestCircle(context.Background(), im, nil, &Options{})
+	if !ok || got != want {
+		t.Errorf("findBestCircle = %s, %v, want %s, true", got, ok, want)
+	}
+	if diag.Coarse != got || diag.Shift != (image.Point{}) || diag.Grow != 0 {
+		t.Errorf("unrefined circle has diagnostics %+v", diag)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,69 @@
+package location
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+const (
+	// confidenceSamples is the number of points around a circle
+	// checked by pupilConfidence.
+	confidenceSamples = 64
+	// confidenceContrast is the minimum brightness step, on a
+	// normalized image, between the inside and outside of a circle
+	// for a sample to count as being on the pupil boundary.
+	confidenceContrast = 15
+	// defaultMinConfidence is used when Options.MinConfidence is
+	// unset.
+	defaultMinConfidence = 0.6
+)
+
+// pupilConfidence returns how much im agrees that c is the pupil
+// boundary, between 0 and 1.
+//
+// Fitters each have their own idea of a good fit (Hough votes, RANSAC
+// inliers, CNN class scores), which aren't comparable with each
+// other. So that results from different fitters can be weighed
+// against each other, this scores them all the same way, from the
+// image alone: the pupil is darker than the iris around it, so just
+// inside the boundary should be darker than just outside it. The
+// confidence is the fraction of points around c where that's true.
+func pupilConfidence(im gocv.Mat, c Circle) float64 {
+	if c.R < 2 {
+		return 0
+	}
+
+	norm := gocv.NewMat()
+	defer norm.Close()
+	gocv.Normalize(im, &norm, 255.0, 0.0, gocv.NormMinMax)
+	gocv.GaussianBlur(norm, &norm, image.Point{5, 5}, 0, 0, gocv.BorderDefault)
+
+	// Sample a couple of pixels either side of the boundary, so that
+	// being off by a pixel doesn't cost anything.
+	const margin = 2
+	b := pixel.Bounds(norm)
+	agree := 0
+	for i := 0; i < confidenceSamples; i++ {
+		angle := 2 * math.Pi * float64(i) / confidenceSamples
+		dx, dy := math.Cos(angle), math.Sin(angle)
+		at := func(r int) image.Point {
+			return c.Point.Add(image.Point{
+				X: int(math.Round(float64(r) * dx)),
+				Y: int(math.Round(float64(r) * dy)),
+			})
+		}
+		in, out := at(c.R-margin), at(c.R+margin)
+		if !in.In(b) || !out.In(b) {
+			continue
+		}
+		if int(pixel.UCharAt(norm, out))-int(pixel.UCharAt(norm, in)) >= confidenceContrast {
+			agree++
+		}
+	}
+
+	return float64(agree) / confidenceSamples
+}
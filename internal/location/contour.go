@@ -0,0 +1,104 @@
+package location
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+// Contour is a closed boundary described in polar coordinates around
+// a center point. R[i] is the distance from Center to the boundary
+// at an angle of i degrees.
+type Contour struct {
+	Center image.Point
+	R      [360]float64
+}
+
+// Point returns the boundary point at deg degrees.
+func (c Contour) Point(deg int) image.Point {
+	deg = ((deg % 360) + 360) % 360
+	rad := float64(deg) * math.Pi / 180.0
+	return image.Point{
+		X: c.Center.X + int(math.Round(c.R[deg]*math.Cos(rad))),
+		Y: c.Center.Y + int(math.Round(c.R[deg]*math.Sin(rad))),
+	}
+}
+
+// FindPupilContour refines pupil, a circle returned by FindPupil,
+// into a contour that follows the actual pupil boundary in im.
+//
+// Constricted and dilated pupils are often not quite circular, with
+// a crenellated edge. Unwrapping the iris using the fitted circle
+// then smears a little pupil into the iris texture, or vice versa. A
+// per-degree contour lets the unwrapping follow the real boundary.
+//
+// The boundary is looked for in the same edge map the edge based
+// fitters use, as configured by opts. opts may be nil, in which case
+// defaults are used.
+func FindPupilContour(im gocv.Mat, pupil Circle, opts *Options) Contour {
+	if opts == nil {
+		opts = &Options{}
+	}
+	edge, _, _, _, _ := pupilEdgeMap(im, opts)
+	defer edge.Close()
+
+	ret := Contour{Center: pupil.Point}
+
+	// Walk outwards along a ray for each degree, and pick the edge
+	// pixel closest to the fitted circle. We only look within a
+	// band around the circle, so that unrelated edges (eyelids,
+	// reflections) don't get picked up. If a ray doesn't cross any
+	// edge, the fitted circle is the best guess we have.
+	band := math.Max(2, float64(pupil.R)/3)
+	var found [360]bool
+	for deg := 0; deg < 360; deg++ {
+		ret.R[deg] = float64(pupil.R)
+
+		rad := float64(deg) * math.Pi / 180.0
+		dx, dy := math.Cos(rad), math.Sin(rad)
+		best := math.Inf(1)
+		for r := float64(pupil.R) - band; r <= float64(pupil.R)+band; r++ {
+			p := pupil.Point.Add(image.Point{
+				X: int(math.Round(r * dx)),
+				Y: int(math.Round(r * dy)),
+			})
+			if !p.In(pixel.Bounds(edge)) || pixel.UCharAt(edge, p) == 0 {
+				continue
+			}
+			if d := math.Abs(r - float64(pupil.R)); d < best {
+				best = d
+				ret.R[deg] = r
+				found[deg] = true
+			}
+		}
+	}
+
+	// Individual rays can still latch onto noise. The real boundary
+	// is smooth at the scale of a few degrees, so a small circular
+	// median filter knocks out single-ray outliers without flattening
+	// genuine crenellations.
+	const window = 5
+	var smoothed [360]float64
+	for deg := range ret.R {
+		var vals []float64
+		for i := -window / 2; i <= window/2; i++ {
+			j := (deg + i + 360) % 360
+			if found[j] {
+				vals = append(vals, ret.R[j])
+			}
+		}
+		if len(vals) == 0 {
+			smoothed[deg] = ret.R[deg]
+			continue
+		}
+		sort.Float64s(vals)
+		smoothed[deg] = vals[len(vals)/2]
+	}
+	ret.R = smoothed
+
+	return ret
+}
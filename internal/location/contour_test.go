@@ -0,0 +1,31 @@
+package location
+
+import (
+	"image"
+	"testing"
+)
+
+func TestContourPoint(t *testing.T) {
+	c := Contour{Center: image.Point{100, 50}}
+	for i := range c.R {
+		c.R[i] = 10
+	}
+	c.R[90] = 20
+	tests := []struct {
+		deg  int
+		want image.Point
+	}{
+		{0, image.Point{110, 50}},
+		// Y points down, so 90° is below the center.
+		{90, image.Point{100, 70}},
+		{180, image.Point{90, 50}},
+		{270, image.Point{100, 40}},
+		{360, image.Point{110, 50}},
+		{-90, image.Point{100, 40}},
+	}
+	for _, test := range tests {
+		if got := c.Point(test.deg); got != test.want {
+			t.Errorf("Point(%d) = %v, want %v", test.deg, got, test.want)
+		}
+	}
+}
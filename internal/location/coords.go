@@ -0,0 +1,27 @@
+package location
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// This package does all its geometry with image.Point and
+// image.Rectangle, as explained in the pixel package. The helpers
+// below, and pixel's, are the only ones that talk to gocv in (row,
+// col) terms.
+
+// setUCharAt sets the 8-bit pixel at p in m to v.
+func setUCharAt(m *gocv.Mat, p image.Point, v uint8) {
+	m.SetUCharAt(p.Y, p.X, v)
+}
+
+// floatAt returns the value of the 32-bit float pixel at p in m.
+func floatAt(m gocv.Mat, p image.Point) float32 {
+	return m.GetFloatAt(p.Y, p.X)
+}
+
+// setFloatAt sets the 32-bit float pixel at p in m to v.
+func setFloatAt(m *gocv.Mat, p image.Point, v float32) {
+	m.SetFloatAt(p.Y, p.X, v)
+}
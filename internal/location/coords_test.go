@@ -0,0 +1,64 @@
+//go:build opencv
+// +build opencv
+
+package location
+
+import (
+	"image"
+	"testing"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+func TestCoordsNonSquare(t *testing.T) {
+	// 3 rows of 7 columns. Any transposition either goes out of
+	// bounds or lands on a different pixel.
+	m := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), 3, 7, gocv.MatTypeCV8U)
+	defer m.Close()
+	if got, want := pixel.Bounds(m), image.Rect(0, 0, 7, 3); got != want {
+		t.Errorf("pixel.Bounds = %v, want %v", got, want)
+	}
+
+	p := image.Point{X: 5, Y: 1}
+	setUCharAt(&m, p, 42)
+	if got := m.GetUCharAt(1, 5); got != 42 {
+		t.Errorf("setUCharAt(%v) didn't set row 1, column 5", p)
+	}
+	if got := pixel.UCharAt(m, p); got != 42 {
+		t.Errorf("pixel.UCharAt(%v) = %d, want 42", p, got)
+	}
+	if got := pixel.UCharAt(m, image.Point{X: 1, Y: 2}); got != 0 {
+		t.Errorf("transposed pixel is %d, want 0", got)
+	}
+
+	f := gocv.NewMatWithSize(3, 7, gocv.MatTypeCV32F)
+	defer f.Close()
+	setFloatAt(&f, p, 1.5)
+	if got := f.GetFloatAt(1, 5); got != 1.5 {
+		t.Errorf("setFloatAt(%v) didn't set row 1, column 5", p)
+	}
+	if got := floatAt(f, p); got != 1.5 {
+		t.Errorf("floatAt(%v) = %v, want 1.5", p, got)
+	}
+}
+
+func TestDrawingNonSquare(t *testing.T) {
+	// gocv's drawing functions take image.Points too, and must agree
+	// with the helpers about which way is which.
+	m := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), 40, 100, gocv.MatTypeCV8U)
+	defer m.Close()
+	c := Circle{Point: image.Point{X: 70, Y: 15}, R: 10}
+	gocv.Circle(&m, c.Point, c.R, white, -1)
+	for _, p := range []image.Point{c.Point, {X: 79, Y: 15}, {X: 70, Y: 24}} {
+		if pixel.UCharAt(m, p) == 0 {
+			t.Errorf("pixel %v inside %s isn't drawn", p, c)
+		}
+	}
+	for _, p := range []image.Point{{X: 50, Y: 15}, {X: 70, Y: 35}} {
+		if pixel.UCharAt(m, p) != 0 {
+			t.Errorf("pixel %v outside %s is drawn", p, c)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+//go:build opencv
+// +build opencv
+
+package location
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestAxisScales(t *testing.T) {
+	big := SyntheticEye(image.Rect(0, 0, 1001, 700), Circle{})
+	defer big.Close()
+	small, mult := shrink(big, 240)
+	defer small.Close()
+	sx, sy := axisScales(big, small)
+	if sy != mult {
+		t.Errorf("row scale %v, want the shrink factor %v", sy, mult)
+	}
+	if got := float64(small.Cols()) * sx; got != 1001 {
+		t.Errorf("column scale %v maps the thumbnail's %d columns to %v, want 1001", sx, small.Cols(), got)
+	}
+}
+
+func TestLocatePupilResized(t *testing.T) {
+	// Wide enough that rounding the thumbnail's width and height
+	// separately makes the axis scales differ.
+	want := Circle{Point: image.Point{613, 229}, R: 70}
+	im := SyntheticEye(image.Rect(0, 0, 1001, 600), want)
+	defer im.Close()
+
+	opts := &Options{MaxImageHeight: 240}
+	r, err := LocatePupilContext(context.Background(), im, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A thumbnail pixel is 2.5 image pixels.
+	const tol = 4
+	if err := nearCircle(r.Pupil, want, tol); err != nil {
+		t.Errorf("pupil: %v", err)
+	}
+	for i, a := range r.Diagnostics.Attempts {
+		if err := nearCircle(a.Pupil, want, tol); err != nil {
+			t.Errorf("attempt %d: %v", i, err)
+		}
+	}
+	// The coarse pass shrinks the 240 row image another 4x.
+	if err := nearCircle(r.Diagnostics.Coarse, want, refineWindow(2.5*4)); err != nil {
+		t.Errorf("coarse: %v", err)
+	}
+}
+
+func TestTrackerROI(t *testing.T) {
+	b := image.Rect(0, 0, 640, 480)
+	first := Circle{Point: image.Point{300, 220}, R: 45}
+	second := Circle{Point: image.Point{312, 214}, R: 46}
+
+	tr := &Tracker{
+		Detector: NewDetector(&Options{TraceContour: true}),
+		ROI:      true,
+	}
+	defer tr.Close()
+	ctx := context.Background()
+	for _, want := range []Circle{first, second} {
+		im := SyntheticEye(b, want)
+		r, err := tr.Track(ctx, im)
+		im.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The second frame is searched in a region of interest
+		// around the first pupil, and must still be reported in
+		// full frame coordinates.
+		if err := nearCircle(r.Pupil, want, selfTestTolerance); err != nil {
+			t.Errorf("pupil: %v", err)
+		}
+		if err := nearCircle(r.Diagnostics.Coarse, want, 2*selfTestTolerance); err != nil {
+			t.Errorf("coarse: %v", err)
+		}
+		for i, a := range r.Diagnostics.Attempts {
+			if err := nearCircle(a.Pupil, want, selfTestTolerance); err != nil {
+				t.Errorf("attempt %d: %v", i, err)
+			}
+		}
+		if r.Contour == nil || r.Contour.Center != r.Pupil.Point {
+			t.Errorf("contour %v isn't centered on pupil %s", r.Contour, r.Pupil)
+		}
+	}
+}
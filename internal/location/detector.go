@@ -0,0 +1,128 @@
+package location
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/houghcircle"
+)
+
+// Detector locates pupils with a fixed set of Options. Setting one up
+// does work that would otherwise be repeated on every call, so
+// prefer a Detector over calling LocatePupil repeatedly, e.g. on
+// video. A Detector is safe for concurrent use.
+type Detector struct {
+	opts Options
+	// accelErr is why the requested accelerator couldn't be used,
+	// or nil.
+	accelErr error
+}
+
+// NewDetector returns a Detector that uses a copy of opts. opts may
+// be nil, in which case defaults are used.
+//
+// If opts sets MaxPupilRadius, the circle rasterizations for every
+// radius the search can consider are computed up front, so that the
+// voting loops only do table lookups. Without it, the radius range
+// depends on each image's size, and only the default coarse radii
+// are precomputed, as they are for LocatePupil.
+func NewDetector(opts *Options) *Detector {
+	d := &Detector{}
+	if opts != nil {
+		d.opts = *opts
+	}
+
+	if max := d.opts.MaxPupilRadius; max > 0 {
+		// Refinement searches beyond the configured range, to
+		// allow for the coarse pass's imprecision. The coarse pass
+		// itself only ever uses smaller radii.
+		max += d.opts.refineMargin()
+		d.opts.circles = houghcircle.NewTable(1, max)
+	}
+
+	if err := probeAccelerator(&d.opts); err != nil {
+		d.accelErr = err
+		d.opts.Accelerator = AccelCPU
+	}
+
+	return d
+}
+
+// Accelerator returns the accelerator d actually uses, and if it's
+// not the one requested in its Options, the reason why.
+func (d *Detector) Accelerator() (Accelerator, error) {
+	return d.opts.Accelerator, d.accelErr
+}
+
+// probeAccelerator checks that opts.Accelerator works, by running the
+// segmentation model on it once.
+func probeAccelerator(opts *Options) error {
+	switch opts.Accelerator {
+	case AccelCPU:
+		return nil
+	case AccelCUDA:
+		return errors.New("the DNN bindings in this gocv version have no CUDA backend")
+	}
+
+	if opts.ModelPath == "" {
+		// Nothing would run on the accelerator anyway.
+		return fmt.Errorf("%s only accelerates the segmentation model, and none is configured", opts.Accelerator)
+	}
+	probe := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(128, 0, 0, 0), segmentHeight, segmentWidth, gocv.MatTypeCV8U)
+	defer probe.Close()
+	seg, err := segment(probe, opts.ModelPath, opts.Accelerator)
+	if err != nil {
+		return err
+	}
+	seg.Close()
+	return nil
+}
+
+// refineRows is the tallest image that NewDetector precomputes
+// refinement circles for, when nothing in the Options bounds the
+// coarse pass's shrink factor.
+const refineRows = 1080
+
+// refineMargin returns how far past MaxPupilRadius refinement can
+// search with o. The coarse radius is off by up to the thumbnail's
+// shrink factor, and refinement searches refineWindow of that factor
+// beyond it. The factor grows with the smallest expected pupil, which
+// defaults to a fraction of the image height, so it's bounded by
+// MinPupilRadius or MaxImageHeight if set, and otherwise only covers
+// images up to refineRows tall. Radii past the margin aren't looked
+// up, but computed on every call.
+func (o *Options) refineMargin() int {
+	var mult float64
+	switch {
+	case o.MinPupilRadius > 0:
+		mult = float64(o.MinPupilRadius) / minPupilRadius
+	case o.MaxImageHeight > 0:
+		mult = float64(o.MaxImageHeight) / coarseHeight
+	default:
+		mult = refineRows / coarseHeight
+	}
+	mult = math.Max(1, mult)
+	return int(math.Ceil(mult)) + refineWindow(mult)
+}
+
+// Options returns a copy of the Options used by d, including its
+// precomputed tables.
+func (d *Detector) Options() Options {
+	return d.opts
+}
+
+// Fingerprint returns the fingerprint of d's Options, which is in
+// every result d returns.
+func (d *Detector) Fingerprint() string {
+	return d.opts.Fingerprint()
+}
+
+// Locate is like LocatePupilContext, using d's Options.
+func (d *Detector) Locate(ctx context.Context, im gocv.Mat) (PupilResult, error) {
+	opts := d.opts
+	return LocatePupilContext(ctx, im, &opts)
+}
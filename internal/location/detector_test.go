@@ -0,0 +1,30 @@
+package location
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRefineMargin(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		maxRows int
+	}{
+		{"default", Options{MaxPupilRadius: 100}, refineRows},
+		{"min radius", Options{MinPupilRadius: 40, MaxPupilRadius: 100}, 4000},
+		{"max height", Options{MaxPupilRadius: 100, MaxImageHeight: 480}, 480},
+	}
+	for _, test := range tests {
+		margin := test.opts.refineMargin()
+		for rows := coarseHeight; rows <= test.maxRows; rows++ {
+			// The shrink factor shrink would use for the coarse
+			// pass.
+			mult := math.Max(1, float64(rows)/float64(test.opts.coarseHeight(rows)))
+			if need := int(math.Ceil(mult)) + refineWindow(mult); need > margin {
+				t.Errorf("%s: %d rows need a margin of %d, have %d", test.name, rows, need, margin)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package location
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Ellipse is an ellipse with sub-pixel precision.
+type Ellipse struct {
+	// X and Y are the coordinates of the center.
+	X, Y float64
+	// A and B are the semi-major and semi-minor axes. A >= B.
+	A, B float64
+	// Angle is the angle of the major axis from the X axis, in
+	// radians. Remember that the Y axis points down in images, so
+	// positive angles are clockwise.
+	Angle float64
+}
+
+func (e Ellipse) String() string {
+	return fmt.Sprintf("(%.1f,%.1f,%.1fx%.1f@%.0f°)", e.X, e.Y, e.A, e.B, e.Angle*180/math.Pi)
+}
+
+// Circle returns the circle with e's center and the same area as e.
+func (e Ellipse) Circle() Circle {
+	return roundCircle(e.X, e.Y, math.Sqrt(e.A*e.B))
+}
+
+// fitEllipse fits an ellipse to pts by least squares. ok is false if
+// pts don't describe an ellipse.
+func fitEllipse(pts []image.Point) (Ellipse, bool) {
+	if len(pts) < 5 {
+		return Ellipse{}, false
+	}
+
+	// A conic is Ax² + Bxy + Cy² + Dx + Ey + F = 0. Fixing F = -1
+	// makes the fit linear, at the cost of failing for conics that
+	// pass through the origin. Shifting all points so that their
+	// centroid is at the origin avoids that, since the centroid of
+	// points on an ellipse is inside the ellipse.
+	var cx, cy float64
+	for _, p := range pts {
+		cx += float64(p.X)
+		cy += float64(p.Y)
+	}
+	cx /= float64(len(pts))
+	cy /= float64(len(pts))
+
+	m := make([][]float64, 5)
+	for i := range m {
+		m[i] = make([]float64, 5)
+	}
+	v := make([]float64, 5)
+	for _, p := range pts {
+		x, y := float64(p.X)-cx, float64(p.Y)-cy
+		row := [5]float64{x * x, x * y, y * y, x, y}
+		for i := range row {
+			for j := range row {
+				m[i][j] += row[i] * row[j]
+			}
+			v[i] += row[i]
+		}
+	}
+	sol, ok := solve(m, v)
+	if !ok {
+		return Ellipse{}, false
+	}
+	A, B, C, D, E := sol[0], sol[1], sol[2], sol[3], sol[4]
+
+	// Not every conic is an ellipse.
+	if B*B-4*A*C >= 0 {
+		return Ellipse{}, false
+	}
+
+	// The center is where the conic's gradient is zero.
+	det := 4*A*C - B*B
+	x0 := (B*E - 2*C*D) / det
+	y0 := (B*D - 2*A*E) / det
+	k := A*x0*x0 + B*x0*y0 + C*y0*y0 + D*x0 + E*y0 - 1
+
+	// Rotate into the ellipse's principal axes, and read off the
+	// axis lengths.
+	theta := 0.5 * math.Atan2(B, A-C)
+	sin, cos := math.Sin(theta), math.Cos(theta)
+	l1 := A*cos*cos + B*cos*sin + C*sin*sin
+	l2 := A*sin*sin - B*cos*sin + C*cos*cos
+	if -k/l1 <= 0 || -k/l2 <= 0 {
+		return Ellipse{}, false
+	}
+	r1, r2 := math.Sqrt(-k/l1), math.Sqrt(-k/l2)
+	if r1 < r2 {
+		r1, r2 = r2, r1
+		theta += math.Pi / 2
+	}
+	if theta > math.Pi/2 {
+		theta -= math.Pi
+	}
+
+	return Ellipse{
+		X:     x0 + cx,
+		Y:     y0 + cy,
+		A:     r1,
+		B:     r2,
+		Angle: theta,
+	}, true
+}
+
+// distance returns the approximate distance from p to e's boundary.
+func (e Ellipse) distance(p image.Point) float64 {
+	// Transform p into the ellipse's frame, and compare its
+	// distance from the center to the ellipse's radius in that
+	// direction.
+	dx, dy := float64(p.X)-e.X, float64(p.Y)-e.Y
+	sin, cos := math.Sin(e.Angle), math.Cos(e.Angle)
+	u, v := dx*cos+dy*sin, -dx*sin+dy*cos
+	d := math.Hypot(u, v)
+	if d == 0 {
+		return e.B
+	}
+	r := e.A * e.B / math.Hypot(e.B*u/d, e.A*v/d)
+	return math.Abs(d - r)
+}
+
+// solve solves the square linear system m·x = v by Gaussian
+// elimination with partial pivoting. m and v are modified. ok is
+// false if the system is singular.
+func solve(m [][]float64, v []float64) (x []float64, ok bool) {
+	n := len(v)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		v[col], v[pivot] = v[pivot], v[col]
+
+		for row := col + 1; row < n; row++ {
+			f := m[row][col] / m[col][col]
+			for k := col; k < n; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+			v[row] -= f * v[col]
+		}
+	}
+
+	x = make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		s := v[row]
+		for k := row + 1; k < n; k++ {
+			s -= m[row][k] * x[k]
+		}
+		x[row] = s / m[row][row]
+	}
+	return x, true
+}
@@ -0,0 +1,70 @@
+package location
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// ellipsePoints returns n points evenly spread around e, rounded to
+// pixels.
+func ellipsePoints(e Ellipse, n int) []image.Point {
+	sin, cos := math.Sin(e.Angle), math.Cos(e.Angle)
+	var ret []image.Point
+	for i := 0; i < n; i++ {
+		t := 2 * math.Pi * float64(i) / float64(n)
+		u, v := e.A*math.Cos(t), e.B*math.Sin(t)
+		ret = append(ret, image.Point{
+			X: int(math.Round(e.X + u*cos - v*sin)),
+			Y: int(math.Round(e.Y + u*sin + v*cos)),
+		})
+	}
+	return ret
+}
+
+func TestFitEllipse(t *testing.T) {
+	tests := []Ellipse{
+		{X: 320, Y: 240, A: 60, B: 60},
+		{X: 320, Y: 240, A: 80, B: 40},
+		{X: 100, Y: 400, A: 50, B: 30, Angle: math.Pi / 6},
+		{X: 500, Y: 60, A: 45, B: 35, Angle: -math.Pi / 3},
+	}
+	for _, want := range tests {
+		got, ok := fitEllipse(ellipsePoints(want, 90))
+		if !ok {
+			t.Errorf("fitEllipse(%s) failed", want)
+			continue
+		}
+		if math.Abs(got.X-want.X) > 0.5 || math.Abs(got.Y-want.Y) > 0.5 || math.Abs(got.A-want.A) > 1 || math.Abs(got.B-want.B) > 1 {
+			t.Errorf("fitEllipse(%s) = %s", want, got)
+		}
+		// A circle has no orientation to check.
+		if want.A-want.B < 5 {
+			continue
+		}
+		d := math.Mod(got.Angle-want.Angle+math.Pi, math.Pi)
+		if d > math.Pi/2 {
+			d -= math.Pi
+		}
+		if math.Abs(d) > 0.05 {
+			t.Errorf("fitEllipse(%s) = %s, angle off by %.3f rad", want, got, d)
+		}
+		if got.A < got.B {
+			t.Errorf("fitEllipse(%s) = %s, want A >= B", want, got)
+		}
+	}
+}
+
+func TestFitEllipseDegenerate(t *testing.T) {
+	if _, ok := fitEllipse(ellipsePoints(Ellipse{X: 10, Y: 10, A: 5, B: 5}, 4)); ok {
+		t.Error("fitEllipse of 4 points succeeded")
+	}
+	// A hyperbola is a conic, but not an ellipse.
+	var hyperbola []image.Point
+	for x := 1; x <= 20; x++ {
+		hyperbola = append(hyperbola, image.Point{100 + x, 100 + 400/x}, image.Point{100 - x, 100 - 400/x})
+	}
+	if e, ok := fitEllipse(hyperbola); ok {
+		t.Errorf("fitEllipse of a hyperbola = %s", e)
+	}
+}
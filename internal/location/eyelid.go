@@ -0,0 +1,152 @@
+package location
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+)
+
+const (
+	// eyelidSector is the half-width, in degrees, of the sectors
+	// above and below the pupil that eyelids are searched in.
+	eyelidSector = 70
+	// eyelidEdgeWidth is how many pixels on either side of a
+	// candidate eyelid edge get compared.
+	eyelidEdgeWidth = 2
+	// eyelidMaxStep is how much, in pixels, the distance to an
+	// eyelid may change from one degree to the next. Eyelids are
+	// nearly straight across the iris, which in polar coordinates
+	// around the pupil curves away steeply towards the sides, so
+	// this is looser than for the limbus.
+	eyelidMaxStep = 4
+	// eyelidMinStrength is the edge strength an eyelid needs to be
+	// preferred over the limbus. Weaker edges are iris texture.
+	eyelidMinStrength = 10
+)
+
+// Eyelid is the edge of an eyelid across the iris, in polar
+// coordinates around the pupil center. R[i] is the distance from
+// Center to the eyelid at From+i degrees. Where the eyelid doesn't
+// cover the iris, R follows the limbus instead, so everything beyond
+// R is occluded or outside the iris.
+type Eyelid struct {
+	Center image.Point
+	From   int
+	R      []float64
+}
+
+// Point returns the eyelid point at From+i degrees.
+func (e Eyelid) Point(i int) image.Point {
+	rad := float64(e.From+i) * math.Pi / 180.0
+	return e.Center.Add(image.Point{
+		X: int(math.Round(e.R[i] * math.Cos(rad))),
+		Y: int(math.Round(e.R[i] * math.Sin(rad))),
+	})
+}
+
+// FindEyelids finds the upper and lower eyelid edges across the iris
+// between pupil and limbus in im.
+//
+// Like FindLimbus, it unwraps the iris into polar coordinates, and
+// follows the strongest smooth dark to bright edge with dynamic
+// programming, in sectors above and below the pupil. That makes no
+// assumption about the eyelid's shape, unlike the usual parabola
+// fits, so the occlusion boundary follows the actual lid.
+func FindEyelids(im gocv.Mat, pupil, limbus Circle) (upper, lower Eyelid, err error) {
+	if pupil.R <= 0 || limbus.R <= pupil.R {
+		return Eyelid{}, Eyelid{}, errs.New(errs.ErrNotFound, "no iris to find eyelids on")
+	}
+
+	blur := gocv.NewMat()
+	defer blur.Close()
+	gocv.MedianBlur(im, &blur, 5)
+
+	minR := pupil.R + eyelidEdgeWidth
+	maxR := int(math.Ceil(dist(pupil.Point, limbus.Point))) + limbus.R
+	polar := unwrapPolar(blur, pupil.Point, minR, maxR)
+	edges := polar.radialEdges(eyelidEdgeWidth)
+
+	// Image Y points down, so the upper eyelid is around 270
+	// degrees, and the lower one around 90.
+	upper = findEyelid(polar, edges, limbus, 270-eyelidSector)
+	lower = findEyelid(polar, edges, limbus, 90-eyelidSector)
+	return upper, lower, nil
+}
+
+// findEyelid finds the eyelid in the sector of polar starting at
+// from degrees. edges are polar's radial edges.
+func findEyelid(polar *polarImage, edges [360][]float64, limbus Circle, from int) Eyelid {
+	score := make([][]float64, 2*eyelidSector+1)
+	for i := range score {
+		deg := (from + i) % 360
+		// Samples past the limbus aren't iris, and can't be
+		// occluded. The limbus itself gets a baseline score, so
+		// that the path runs along it unless an eyelid edge
+		// stronger than that cuts in.
+		end := int(rayToCircle(polar.center, deg, limbus)) - polar.minR
+		end = max(0, min(end, len(edges[deg])-1))
+		score[i] = make([]float64, len(edges[deg]))
+		for j := range score[i] {
+			switch {
+			case j < end:
+				score[i][j] = edges[deg][j]
+			case j == end:
+				score[i][j] = eyelidMinStrength
+			default:
+				score[i][j] = math.Inf(-1)
+			}
+		}
+	}
+
+	ret := Eyelid{Center: polar.center, From: from}
+	for _, j := range bestPath(score, eyelidMaxStep) {
+		ret.R = append(ret.R, polar.radius(j))
+	}
+	return ret
+}
+
+// rayToCircle returns the distance from p, which must be inside c,
+// to c's edge at deg degrees.
+func rayToCircle(p image.Point, deg int, c Circle) float64 {
+	rad := float64(deg) * math.Pi / 180.0
+	ux, uy := math.Cos(rad), math.Sin(rad)
+	dx, dy := float64(p.X-c.X), float64(p.Y-c.Y)
+	// Solve |d + t*u| = c.R for the positive t.
+	du := dx*ux + dy*uy
+	disc := du*du - (dx*dx + dy*dy) + float64(c.R*c.R)
+	if disc < 0 {
+		return 0
+	}
+	return -du + math.Sqrt(disc)
+}
+
+// EyelidNoise returns a mask the size of b, white where upper or
+// lower occlude the iris, for use as the noise mask of an iris strip.
+func EyelidNoise(b image.Rectangle, upper, lower Eyelid) gocv.Mat {
+	ret := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), b.Dy(), b.Dx(), gocv.MatTypeCV8U)
+	// Everything beyond the eyelid is occluded. Close each eyelid's
+	// polygon well outside the image, along the sector's edges.
+	far := float64(b.Dx() + b.Dy())
+	for _, e := range []Eyelid{upper, lower} {
+		if len(e.R) == 0 {
+			continue
+		}
+		var poly []image.Point
+		for i := range e.R {
+			poly = append(poly, e.Point(i))
+		}
+		outer := Eyelid{Center: e.Center, From: e.From, R: make([]float64, len(e.R))}
+		for i := range outer.R {
+			outer.R[i] = far
+		}
+		for i := len(outer.R) - 1; i >= 0; i-- {
+			poly = append(poly, outer.Point(i))
+		}
+		gocv.FillPoly(&ret, [][]image.Point{poly}, color.RGBA{255, 255, 255, 0})
+	}
+	return ret
+}
@@ -0,0 +1,77 @@
+package location
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.universe.tf/iris/internal/model"
+)
+
+// algorithmVersion identifies the localization algorithms. Bump it
+// whenever a change alters results for the same Options, so that
+// fingerprints from before and after don't match.
+const algorithmVersion = 5
+
+var (
+	// modelHashesMu guards modelHashes.
+	modelHashesMu sync.Mutex
+	// modelHashes caches the SHA-256 of model files by path, so that
+	// fingerprinting doesn't reread a large model on every image.
+	modelHashes = map[string]string{}
+)
+
+// Fingerprint returns a short identifier of the pipeline that o
+// configures: the algorithm version, every setting that can change
+// results, and the contents of the segmentation model. Results with
+// different fingerprints may not be comparable.
+//
+// Settings that only affect speed, like Parallelism, are left out.
+// So is Accelerator, since it shouldn't change the model's output
+// beyond floating point noise.
+func (o *Options) Fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "algorithm=%d\n", algorithmVersion)
+	fmt.Fprintf(h, "fitter=%s\n", o.Fitter)
+	for _, f := range o.Fallback {
+		fmt.Fprintf(h, "fallback=%s\n", f)
+	}
+	fmt.Fprintf(h, "radius=%d-%d\n", o.MinPupilRadius, o.MaxPupilRadius)
+	fmt.Fprintf(h, "max-height=%d\n", o.MaxImageHeight)
+	fmt.Fprintf(h, "no-smoothing=%t\n", o.NoAccumulatorSmoothing)
+	fmt.Fprintf(h, "close-edge-gaps=%t\n", o.CloseEdgeGaps)
+	fmt.Fprintf(h, "edge-combination=%s\n", o.EdgeCombination)
+	fmt.Fprintf(h, "thin-edges=%t\n", o.ThinEdges)
+	fmt.Fprintf(h, "dark-fraction=%g\n", o.DarkFraction)
+	fmt.Fprintf(h, "edge-blur=%d\n", o.EdgeBlur)
+	fmt.Fprintf(h, "open-kernel=%d\n", o.OpenKernel)
+	fmt.Fprintf(h, "min-confidence=%g\n", o.MinConfidence)
+	for _, r := range o.Retries {
+		fmt.Fprintf(h, "retry=%s\n", r)
+	}
+	fmt.Fprintf(h, "gaze=%t\n", o.EstimateGaze)
+	fmt.Fprintf(h, "contour=%t\n", o.TraceContour)
+	if o.ModelPath != "" {
+		io.WriteString(h, "model="+modelHash(o.ModelPath)+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// modelHash returns the SHA-256 of the model file at path. If it
+// can't be read, the path itself stands in, which still tells
+// different models apart.
+func modelHash(path string) string {
+	modelHashesMu.Lock()
+	defer modelHashesMu.Unlock()
+	if h, ok := modelHashes[path]; ok {
+		return h
+	}
+	h, err := model.HashFile(path)
+	if err != nil {
+		return "path:" + path
+	}
+	modelHashes[path] = h
+	return h
+}
@@ -0,0 +1,73 @@
+package location
+
+import (
+	"context"
+	"image"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+)
+
+// FitCircle finds the single best defined circle in edgeOrMask, using
+// the same coarse Hough search and refinement as the default pupil
+// fitter, but none of its preprocessing. This is for callers that
+// have their own edge detection or segmentation, and only need the
+// circle fitting. opts may be nil, in which case defaults are used.
+// Only the radius range and performance options apply.
+//
+// edgeOrMask is an 8-bit single channel image, where nonzero pixels
+// are either points on the circle's boundary, or the filled region
+// the circle bounds. A filled region is reduced to its boundary
+// before fitting, and an edge map passes through unchanged.
+//
+// If refinement fails, the circle is the coarse estimate, and
+// Diagnostics.RefinedVotes is zero.
+func FitCircle(edgeOrMask gocv.Mat, opts *Options) (Circle, Diagnostics, error) {
+	return FitCircleContext(context.Background(), edgeOrMask, opts)
+}
+
+// FitCircleContext is like FitCircle, but gives up as soon as possible
+// once ctx is done, and returns ctx's error.
+func FitCircleContext(ctx context.Context, edgeOrMask gocv.Mat, opts *Options) (Circle, Diagnostics, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	edge := maskBoundary(edgeOrMask)
+	defer edge.Close()
+	if gocv.CountNonZero(edge) == 0 {
+		return Circle{}, Diagnostics{}, errs.New(errs.ErrNotFound, "no candidate pixels to fit a circle to")
+	}
+
+	c, diag, _ := findBestCircle(ctx, edge, nil, opts)
+	if err := ctx.Err(); err != nil {
+		return Circle{}, Diagnostics{}, errs.FromContext(err)
+	}
+	if c.R == 0 {
+		return Circle{}, Diagnostics{}, errs.New(errs.ErrNotFound, "no circle found")
+	}
+	return c, diag, nil
+}
+
+// maskBoundary returns the pixels of im that are nonzero, but have a
+// zero pixel next to them. That's the outline of filled regions, and
+// all of thin lines, so edge maps come out as they went in.
+func maskBoundary(im gocv.Mat) gocv.Mat {
+	bin := gocv.NewMat()
+	defer bin.Close()
+	gocv.Threshold(im, &bin, 0, 255, gocv.ThresholdBinary)
+
+	// A 3x3 erosion strips the outermost pixel off filled regions,
+	// and erases lines a couple of pixels thick entirely.
+	// Subtracting it leaves exactly the outline.
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Point{3, 3})
+	defer kernel.Close()
+	inner := gocv.NewMat()
+	defer inner.Close()
+	gocv.Erode(bin, &inner, kernel)
+
+	ret := gocv.NewMat()
+	gocv.Subtract(bin, inner, &ret)
+	return ret
+}
@@ -0,0 +1,127 @@
+package location
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+const (
+	// frstAlpha is the radial strictness of the fast radial
+	// symmetry transform. Higher values reject non-radially
+	// symmetric shapes more aggressively.
+	frstAlpha = 2
+	// frstGradientFloor is the fraction of the strongest gradient
+	// below which pixels don't vote.
+	frstGradientFloor = 0.1
+)
+
+// radialSymmetry locates the pupil in im using the fast radial
+// symmetry transform. It returns an approximate circle, and a refined
+// one.
+//
+// This is the transform from "Fast Radial Symmetry for Detecting
+// Points of Interest", by Loy and Zelinsky. It is much cheaper than
+// the Hough transform, since each pixel casts a single vote per
+// radius instead of a whole circle of votes, which makes it suitable
+// for video-rate tracking on small hardware. In exchange, it is less
+// precise and more easily fooled by other round dark things.
+func radialSymmetry(im gocv.Mat, opts *Options) (Circle, Circle) {
+	small, mult := shrink(im, opts.coarseHeight(pixel.Bounds(im).Dy()))
+	minR, maxR := opts.coarseRadii(pixel.Bounds(im).Dy(), mult)
+	defer small.Close()
+	gocv.Normalize(small, &small, 255.0, 0.0, gocv.NormMinMax)
+	gocv.GaussianBlur(small, &small, image.Point{3, 3}, 0, 0, gocv.BorderDefault)
+
+	dx := gocv.NewMat()
+	defer dx.Close()
+	gocv.Sobel(small, &dx, gocv.MatTypeCV32F, 1, 0, 3, 1, 0, gocv.BorderDefault)
+	dy := gocv.NewMat()
+	defer dy.Close()
+	gocv.Sobel(small, &dy, gocv.MatTypeCV32F, 0, 1, 3, 1, 0, gocv.BorderDefault)
+
+	// The accumulators below are flat slices, indexed by
+	// y*width+x.
+	b := pixel.Bounds(small)
+	w := b.Dx()
+
+	var maxMag float64
+	mag := make([]float64, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			p := image.Point{x, y}
+			m := math.Hypot(float64(floatAt(dx, p)), float64(floatAt(dy, p)))
+			mag[y*w+x] = m
+			maxMag = math.Max(maxMag, m)
+		}
+	}
+	floor := maxMag * frstGradientFloor
+
+	var (
+		winner      Circle
+		winnerScore float64
+	)
+	for r := minR; r < maxR; r++ {
+		// Every pixel with a strong enough gradient votes for the
+		// pixel r steps "downhill" from it as a possible center of
+		// a dark circle. O counts votes, M sums their gradient
+		// magnitudes.
+		o := make([]float64, len(mag))
+		m := make([]float64, len(mag))
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				p := image.Point{x, y}
+				g := mag[y*w+x]
+				if g < floor {
+					continue
+				}
+				gx := float64(floatAt(dx, p)) / g
+				gy := float64(floatAt(dy, p)) / g
+				c := p.Sub(image.Point{
+					X: int(math.Round(gx * float64(r))),
+					Y: int(math.Round(gy * float64(r))),
+				})
+				if !c.In(b) {
+					continue
+				}
+				o[c.Y*w+c.X]++
+				m[c.Y*w+c.X] += g
+			}
+		}
+
+		// Combine O and M into this radius's symmetry map. The
+		// normalizing constant comes from the paper, for radii
+		// above 1.
+		const k = 9.9
+		f := gocv.NewMatWithSize(b.Dy(), b.Dx(), gocv.MatTypeCV32F)
+		for i := range o {
+			oi := math.Min(o[i], k)
+			setFloatAt(&f, image.Point{i % w, i / w}, float32((m[i]/k)*math.Pow(oi/k, frstAlpha)))
+		}
+
+		// Spread each vote out a little, in proportion to the
+		// radius, so that near misses reinforce each other.
+		sz := 2*(r/4) + 1
+		gocv.GaussianBlur(f, &f, image.Point{sz, sz}, 0.25*float64(r), 0, gocv.BorderDefault)
+
+		_, score, _, loc := gocv.MinMaxLoc(f)
+		f.Close()
+		if float64(score) > winnerScore {
+			winnerScore = float64(score)
+			winner = Circle{Point: loc, R: r}
+		}
+	}
+
+	sx, sy := axisScales(im, small)
+	approximate := Circle{
+		Point: image.Point{
+			X: int((float64(winner.X) + 0.5) * sx),
+			Y: int((float64(winner.Y) + 0.5) * sy),
+		},
+		R: int(math.Round(float64(winner.R) * (sx + sy) / 2)),
+	}
+	return approximate, approximate
+}
@@ -0,0 +1,97 @@
+package location
+
+import (
+	"fmt"
+	"math"
+)
+
+// Gaze is a rough estimate of where an eye is looking, relative to
+// the camera.
+//
+// It comes from the pupil's outline: a circular pupil seen off-axis
+// looks like an ellipse, squashed along the direction the eye turned,
+// with its axis ratio equal to the cosine of the angle it turned by.
+// That gives how far, and along which axis, the eye looks away from
+// the camera, but not to which side. Looking 20° left and 20° right
+// produce the same ellipse.
+//
+// The side comes from the limbus, see Orient. Without it, the angles
+// are magnitudes, which is enough to tell a subject to look at the
+// camera, but not which way to look.
+type Gaze struct {
+	// OffAxis is the angle between the gaze and the camera's line of
+	// sight, in degrees.
+	OffAxis float64
+	// Horizontal and Vertical split OffAxis into its horizontal and
+	// vertical components, in degrees. If Signed, they are positive
+	// for gazes towards the right and the bottom of the image, and
+	// negative towards the left and the top. Otherwise, they are
+	// magnitudes.
+	Horizontal, Vertical float64
+	// Signed is true if the side the eye turned to is known.
+	Signed bool
+}
+
+func (g Gaze) String() string {
+	if !g.Signed {
+		return fmt.Sprintf("%.0f° off-axis (%.0f° horizontal, %.0f° vertical)", g.OffAxis, g.Horizontal, g.Vertical)
+	}
+	h, v := "right", "down"
+	if g.Horizontal < 0 {
+		h = "left"
+	}
+	if g.Vertical < 0 {
+		v = "up"
+	}
+	return fmt.Sprintf("%.0f° off-axis (%.0f° %s, %.0f° %s)", g.OffAxis, math.Abs(g.Horizontal), h, math.Abs(g.Vertical), v)
+}
+
+// minGazeAxis is the smallest semi-minor axis, in pixels, of a pupil
+// ellipse that EstimateGaze trusts. Below that, one pixel of fitting
+// noise swings the axis ratio by several degrees.
+const minGazeAxis = 5
+
+// minGazeOffset is the smallest distance, in pixels, between the
+// pupil and limbus centers that Orient trusts to tell which side the
+// eye turned to.
+const minGazeOffset = 1
+
+// EstimateGaze estimates the gaze from the pupil ellipse e, as found
+// by FindPupilEllipse. ok is false if e is too small to say anything
+// useful.
+func EstimateGaze(e Ellipse) (g Gaze, ok bool) {
+	if e.B < minGazeAxis || e.A < e.B {
+		return Gaze{}, false
+	}
+
+	tilt := math.Acos(e.B / e.A)
+	// The eye turned along the minor axis, perpendicular to
+	// e.Angle.
+	g.OffAxis = tilt * 180 / math.Pi
+	g.Horizontal = g.OffAxis * math.Abs(math.Sin(e.Angle))
+	g.Vertical = g.OffAxis * math.Abs(math.Cos(e.Angle))
+	return g, true
+}
+
+// Orient returns g with the side the eye turned to filled in, from
+// the pupil ellipse g was estimated from and the limbus circle, as
+// found by FindLimbus. If the two are too close to concentric to
+// tell, g is returned unchanged.
+//
+// The pupil sits a little behind the plane of the limbus, so it's
+// nearer the eye's center of rotation. When the eye turns, the
+// limbus swings further towards the gaze than the pupil does, and the
+// pupil appears off center on the side away from the gaze.
+func (g Gaze) Orient(pupil Ellipse, limbus Circle) Gaze {
+	if g.Signed {
+		return g
+	}
+	dx, dy := pupil.X-float64(limbus.X), pupil.Y-float64(limbus.Y)
+	if math.Hypot(dx, dy) < minGazeOffset {
+		return g
+	}
+	g.Horizontal = math.Copysign(g.Horizontal, -dx)
+	g.Vertical = math.Copysign(g.Vertical, -dy)
+	g.Signed = true
+	return g
+}
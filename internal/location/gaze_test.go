@@ -0,0 +1,67 @@
+package location
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestEstimateGaze(t *testing.T) {
+	// Turned 60° sideways: the pupil is half as wide as it is tall,
+	// so its major axis is vertical.
+	g, ok := EstimateGaze(Ellipse{X: 100, Y: 100, A: 20, B: 10, Angle: math.Pi / 2})
+	if !ok {
+		t.Fatal("EstimateGaze failed")
+	}
+	if math.Abs(g.OffAxis-60) > 1e-9 || math.Abs(g.Horizontal-60) > 1e-9 || math.Abs(g.Vertical) > 1e-9 || g.Signed {
+		t.Errorf("EstimateGaze = %+v, want 60° horizontal, unsigned", g)
+	}
+
+	if _, ok := EstimateGaze(Ellipse{A: 4, B: 3}); ok {
+		t.Error("EstimateGaze trusted a tiny pupil")
+	}
+}
+
+func TestGazeOrient(t *testing.T) {
+	unsigned := Gaze{OffAxis: 30, Horizontal: 24, Vertical: 18}
+	limbus := Circle{Point: image.Point{100, 100}, R: 60}
+	tests := []struct {
+		pupil Ellipse
+		want  Gaze
+	}{
+		// Pupil left of and above the limbus center: looking right
+		// and down.
+		{Ellipse{X: 97, Y: 98}, Gaze{OffAxis: 30, Horizontal: 24, Vertical: 18, Signed: true}},
+		{Ellipse{X: 103, Y: 102}, Gaze{OffAxis: 30, Horizontal: -24, Vertical: -18, Signed: true}},
+		{Ellipse{X: 103, Y: 97}, Gaze{OffAxis: 30, Horizontal: -24, Vertical: 18, Signed: true}},
+		// Too close to concentric to tell.
+		{Ellipse{X: 100.5, Y: 99.6}, unsigned},
+	}
+	for _, test := range tests {
+		if got := unsigned.Orient(test.pupil, limbus); got != test.want {
+			t.Errorf("Orient(%s) = %+v, want %+v", test.pupil, got, test.want)
+		}
+	}
+
+	// Orienting twice doesn't flip the signs back.
+	g := unsigned.Orient(tests[1].pupil, limbus)
+	if got := g.Orient(tests[0].pupil, limbus); got != g {
+		t.Errorf("reorienting %+v gave %+v", g, got)
+	}
+}
+
+func TestGazeString(t *testing.T) {
+	tests := []struct {
+		g    Gaze
+		want string
+	}{
+		{Gaze{OffAxis: 30, Horizontal: 24, Vertical: 18}, "30° off-axis (24° horizontal, 18° vertical)"},
+		{Gaze{OffAxis: 30, Horizontal: -24, Vertical: 18, Signed: true}, "30° off-axis (24° left, 18° down)"},
+		{Gaze{OffAxis: 30, Horizontal: 24, Vertical: -18, Signed: true}, "30° off-axis (24° right, 18° up)"},
+	}
+	for _, test := range tests {
+		if got := test.g.String(); got != test.want {
+			t.Errorf("%+v.String() = %q, want %q", test.g, got, test.want)
+		}
+	}
+}
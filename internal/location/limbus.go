@@ -0,0 +1,135 @@
+package location
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+)
+
+const (
+	// limbusMinScale and limbusMaxScale bound the limbus radius, in
+	// pupil radii. A fully dilated pupil is about 8mm across, and a
+	// fully constricted one 2mm, for an iris of about 12mm.
+	limbusMinScale = 1.4
+	limbusMaxScale = 6
+	// limbusEdgeWidth is how many pixels on either side of a
+	// candidate boundary get compared, which smooths over iris
+	// texture.
+	limbusEdgeWidth = 3
+	// limbusMaxStep is how much, in pixels, the limbus radius may
+	// change from one degree to the next. The limbus is close to a
+	// circle, and not quite concentric with the pupil, so the radius
+	// only changes slowly.
+	limbusMaxStep = 2
+	// limbusSector is the half-width, in degrees, of the sectors on
+	// either side of the iris that the limbus circle is fitted to.
+	// Eyelids often cover the top and bottom of the limbus, but
+	// rarely the sides.
+	limbusSector = 45
+	// limbusContrastBand is the width, in pixels, of the bands just
+	// inside and outside the limbus whose brightness is compared to
+	// measure its contrast. limbusContrastGap pixels right at the
+	// boundary are skipped, since they're a blend of both sides.
+	limbusContrastBand = 6
+	limbusContrastGap  = 2
+	// MinLimbusContrast is the Limbus.Contrast below which the
+	// limbus is too faint to trust.
+	MinLimbusContrast = 0.1
+)
+
+// Limbus is the outer boundary of the iris, as found by FindLimbus.
+type Limbus struct {
+	// Circle is a circle fitted to the sides of Contour.
+	Circle Circle
+	// Contour is the boundary traced around the pupil center. Where
+	// eyelids cover the limbus, it follows the eyelid instead.
+	Contour Contour
+	// Contrast is how much brighter the sclera is than the iris
+	// across Circle, on the sides of the iris: the Michelson
+	// contrast of the two, between -1 and 1. Below
+	// MinLimbusContrast, the boundary is probably not the limbus,
+	// and shouldn't be relied on.
+	Contrast float64
+}
+
+// FindLimbus finds the limbus, the outer boundary of the iris, around
+// pupil in im.
+//
+// The annulus where the limbus can be is unwrapped into polar
+// coordinates, where the limbus becomes a roughly horizontal dark to
+// bright edge. The smoothest strong path along that edge is found by
+// dynamic programming, which is both faster and more robust than a
+// second Hough transform. The path is returned as a contour around
+// the pupil's center. Where eyelids cover the limbus, it follows the
+// eyelid instead, so the circle is fitted only to the sides of the
+// contour.
+func FindLimbus(im gocv.Mat, pupil Circle) (Limbus, error) {
+	if pupil.R <= 0 {
+		return Limbus{}, errs.New(errs.ErrNotFound, "no pupil to find the limbus around")
+	}
+
+	// Median blurring wipes out most of the iris texture and
+	// eyelashes, but keeps the limbus sharp.
+	blur := gocv.NewMat()
+	defer blur.Close()
+	gocv.MedianBlur(im, &blur, 7)
+
+	minR := int(float64(pupil.R) * limbusMinScale)
+	maxR := int(float64(pupil.R) * limbusMaxScale)
+	polar := unwrapPolar(blur, pupil.Point, minR, maxR)
+	// Degree 0 points right, into a side of the iris where the
+	// limbus is usually clear, so bestPath's seam is harmless.
+	edges := polar.radialEdges(limbusEdgeWidth)
+	path := bestPath(edges[:], limbusMaxStep)
+
+	contour := Contour{Center: pupil.Point}
+	for deg, i := range path {
+		contour.R[deg] = polar.radius(i)
+	}
+
+	var pts []image.Point
+	for deg := -limbusSector; deg <= limbusSector; deg++ {
+		pts = append(pts, contour.Point(deg), contour.Point(180+deg))
+	}
+	x, y, r, ok := leastSquaresCircle(pts)
+	if !ok {
+		return Limbus{Contour: contour}, errs.New(errs.ErrNotFound, "limbus contour doesn't fit a circle")
+	}
+	c := roundCircle(x, y, r)
+	return Limbus{
+		Circle:   c,
+		Contour:  contour,
+		Contrast: limbusContrast(blur, c),
+	}, nil
+}
+
+// limbusContrast returns the Michelson contrast between the bands
+// just outside and just inside c in im, over the sides of c.
+func limbusContrast(im gocv.Mat, c Circle) float64 {
+	minR := max(1, c.R-limbusContrastGap-limbusContrastBand)
+	maxR := c.R + limbusContrastGap + limbusContrastBand
+	polar := unwrapPolar(im, c.Point, minR, maxR)
+	var in, out float64
+	for deg := -limbusSector; deg <= limbusSector; deg++ {
+		for _, d := range []int{deg, 180 + deg} {
+			ray := polar.at[(d+360)%360]
+			for i, v := range ray {
+				switch r := polar.minR + i; {
+				case r < c.R-limbusContrastGap:
+					in += v
+				case r > c.R+limbusContrastGap:
+					out += v
+				}
+			}
+		}
+	}
+	if in+out == 0 {
+		return 0
+	}
+	// Both bands have the same number of samples, except when the
+	// inner one is clipped at the center, which only happens for
+	// implausibly tiny circles. Sums work as well as means then.
+	return (out - in) / (out + in)
+}
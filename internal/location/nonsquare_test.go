@@ -0,0 +1,54 @@
+//go:build opencv
+// +build opencv
+
+package location
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+// Most transposition bugs are invisible on square images. On these,
+// a pupil found with X and Y swapped is out of bounds.
+var nonSquare = []struct {
+	name   string
+	bounds image.Rectangle
+	pupil  Circle
+}{
+	{"wide", image.Rect(0, 0, 640, 200), Circle{Point: image.Point{470, 95}, R: 30}},
+	{"tall", image.Rect(0, 0, 200, 640), Circle{Point: image.Point{95, 430}, R: 60}},
+}
+
+func TestLocatePupilNonSquare(t *testing.T) {
+	for _, test := range nonSquare {
+		for _, f := range []Fitter{FitHough, FitRANSAC, FitRadialSymmetry} {
+			im := SyntheticEye(test.bounds, test.pupil)
+			r, err := LocatePupilContext(context.Background(), im, &Options{Fitter: f})
+			im.Close()
+			if err != nil {
+				t.Errorf("%s, %s: %v", test.name, f, err)
+				continue
+			}
+			if err := nearCircle(r.Pupil, test.pupil, selfTestTolerance); err != nil {
+				t.Errorf("%s, %s: %v", test.name, f, err)
+			}
+		}
+	}
+}
+
+func TestLocatePupilNonSquareResized(t *testing.T) {
+	for _, test := range nonSquare {
+		im := SyntheticEye(test.bounds, test.pupil)
+		r, err := LocatePupilContext(context.Background(), im, &Options{MaxImageHeight: test.bounds.Dy() / 2})
+		im.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		// Half resolution doubles the error.
+		if err := nearCircle(r.Pupil, test.pupil, 2*selfTestTolerance); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+//go:build opencv
+// +build opencv
+
+// Tests in files tagged opencv run images through OpenCV, and need it
+// installed. Run them with:
+//
+//	go test -tags opencv ./...
+//
+// Without the tag, only the tests of the pure Go logic run.
+
+package location
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"testing"
+)
+
+// nearCircle returns an error if got isn't within tol pixels of want,
+// on both center and radius.
+func nearCircle(got, want Circle, tol int) error {
+	d := got.Point.Sub(want.Point)
+	dr := got.R - want.R
+	if d.X*d.X+d.Y*d.Y > tol*tol || dr < -tol || dr > tol {
+		return fmt.Errorf("got %s, want %s within %dpx", got, want, tol)
+	}
+	return nil
+}
+
+func TestSelfTest(t *testing.T) {
+	for _, f := range []Fitter{FitHough, FitRANSAC, FitRadialSymmetry} {
+		if err := SelfTest(context.Background(), &Options{Fitter: f}); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+}
+
+func TestLocatePupilSynthetic(t *testing.T) {
+	b := image.Rect(0, 0, 640, 480)
+	pupils := []Circle{
+		{Point: image.Point{320, 240}, R: 40},
+		{Point: image.Point{200, 300}, R: 60},
+		{Point: image.Point{450, 150}, R: 30},
+	}
+	for _, want := range pupils {
+		im := SyntheticEye(b, want)
+		r, err := LocatePupilContext(context.Background(), im, nil)
+		im.Close()
+		if err != nil {
+			t.Errorf("%s: %v", want, err)
+			continue
+		}
+		if err := nearCircle(r.Pupil, want, selfTestTolerance); err != nil {
+			t.Errorf("%s: %v", want, err)
+		}
+		if r.Confidence < defaultMinConfidence || !r.Confident {
+			t.Errorf("%s: confidence %.2f, want at least %.2f", want, r.Confidence, defaultMinConfidence)
+		}
+		if len(r.Reasons) != 0 {
+			t.Errorf("%s: confident result has reasons %v", want, r.Reasons)
+		}
+	}
+}
+
+func TestLocatePupilEmpty(t *testing.T) {
+	im := SyntheticEye(image.Rect(0, 0, 640, 480), Circle{})
+	defer im.Close()
+	r, err := LocatePupilContext(context.Background(), im, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Confident {
+		t.Errorf("found confident pupil %s in a blank image", r.Pupil)
+	}
+}
+
+func TestTraceContour(t *testing.T) {
+	want := Circle{Point: image.Point{300, 220}, R: 45}
+	im := SyntheticEye(image.Rect(0, 0, 640, 480), want)
+	defer im.Close()
+	for _, opts := range []*Options{
+		{TraceContour: true},
+		{TraceContour: true, MaxImageHeight: 240},
+		{TraceContour: true, EdgeCombination: CombineProduct},
+	} {
+		r, err := LocatePupilContext(context.Background(), im, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Contour == nil {
+			t.Errorf("%+v: no contour", opts)
+			continue
+		}
+		if r.Contour.Center != r.Pupil.Point {
+			t.Errorf("%+v: contour centered on %v, pupil on %v", opts, r.Contour.Center, r.Pupil.Point)
+		}
+		for deg, cr := range r.Contour.R {
+			p := r.Contour.Point(deg)
+			if d := dist(p, want.Point); d < float64(want.R)-selfTestTolerance || d > float64(want.R)+selfTestTolerance {
+				t.Errorf("%+v: contour at %d° is %v (radius %.1f), %.1f from the pupil center, want %d", opts, deg, p, cr, d, want.R)
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkDetectorLocate(b *testing.B) {
+	im := SyntheticEye(image.Rect(0, 0, 640, 480), selfTestPupil)
+	defer im.Close()
+	det := NewDetector(nil)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := det.Locate(ctx, im); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package location
+
+import (
+	"fmt"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/houghcircle"
+)
+
+// Options tunes how FindPupil searches for the pupil. The zero value
+// selects the default behavior.
+type Options struct {
+	// Fitter is the algorithm used to find the pupil circle.
+	Fitter Fitter
+
+	// MinPupilRadius and MaxPupilRadius are the smallest and largest
+	// pupil radii to expect, in pixels. These depend mostly on the
+	// camera and the capture distance. If unset, the pupil radius is
+	// assumed to be between 1/12th and 1/4 of the image height.
+	MinPupilRadius int
+	MaxPupilRadius int
+
+	// MaxImageHeight, if non-zero, shrinks input images taller than
+	// this many pixels before doing anything else. This trades
+	// precision for speed on slow hardware. Results are still
+	// reported in the original image's coordinates.
+	MaxImageHeight int
+
+	// NoAccumulatorSmoothing disables blurring the Hough accumulator
+	// before picking its peak. Smoothing makes peak selection more
+	// robust to votes being split between adjacent cells, at the
+	// cost of a little localization precision in the coarse pass.
+	NoAccumulatorSmoothing bool
+
+	// CloseEdgeGaps closes small gaps in the pupil edge map before
+	// fitting, sized relative to the smallest expected pupil. This
+	// rescues patchy edges, e.g. behind mascara or in low contrast,
+	// whose short arcs would otherwise vote too weakly. The bridged
+	// edges are blurrier, so the fitted circle is a little less
+	// precise.
+	CloseEdgeGaps bool
+
+	// EdgeCombination is how the pupil edge map merges its two
+	// intermediate edge maps.
+	EdgeCombination EdgeCombination
+
+	// ThinEdges thins the pupil edge map to one pixel wide ridges
+	// before fitting, by non-maximum suppression along the image
+	// gradient. Sobel edges are a few pixels thick, so each true
+	// edge otherwise votes for a band of adjacent circles, splitting
+	// the accumulator peak between them.
+	ThinEdges bool
+
+	// DarkFraction, if non-zero, is the fraction of the image,
+	// between 0 and 1, that the pupil edge map takes to be dark
+	// enough to be pupil. If unset, pixels darker than a fixed level
+	// after contrast stretching are, which is roughly the darkest 10%
+	// of a typical NIR eye image.
+	DarkFraction float64
+
+	// EdgeBlur, if non-zero, is the size in pixels of the Gaussian
+	// blur applied before computing the pupil edge map. If unset,
+	// the blur starts at 5x5, and gets stronger until the edge map
+	// is sparse enough to vote on.
+	EdgeBlur int
+
+	// OpenKernel, if non-zero, is the size in pixels of the
+	// morphological opening that wipes specks of noise off the dark
+	// regions of the image, before their edges are taken. Larger
+	// kernels remove more noise, but also eat into small pupils. If
+	// unset, 7 is used.
+	OpenKernel int
+
+	// ModelPath is the segmentation model used by FitCNN.
+	ModelPath string
+
+	// Fallback lists fitters to try, in order, when Fitter's result
+	// has a confidence below MinConfidence. A typical policy is to
+	// run a cheap classical fitter first, and fall back to FitCNN
+	// on images it can't handle.
+	Fallback []Fitter
+	// MinConfidence is the confidence, between 0 and 1, below which
+	// fallback fitters get tried. If unset, 0.6 is used.
+	MinConfidence float64
+	// Retries lists alternate parameter sets to try, in order, when
+	// no fitter, fallbacks included, reached MinConfidence.
+	Retries []Retry
+
+	// EstimateGaze, if true, fits an ellipse to the pupil after
+	// locating it, and estimates the gaze direction from it, and
+	// from the limbus if it's clear enough.
+	EstimateGaze bool
+
+	// TraceContour, if true, follows the pupil boundary around the
+	// located pupil with FindPupilContour, for pupils that aren't
+	// quite circular.
+	TraceContour bool
+
+	// Accelerator is the hardware that runs the CNN segmentation
+	// model. The classical fitters always run on the CPU. A Detector
+	// checks that the requested accelerator works when it's created,
+	// and falls back to AccelCPU if not.
+	Accelerator Accelerator
+
+	// Parallelism is the number of goroutines that share the Hough
+	// voting and refinement work for an image. If zero, one per CPU
+	// is used.
+	Parallelism int
+
+	// circles holds precomputed circle rasterizations by radius,
+	// filled in by NewDetector. It's read-only once set, and shared
+	// between copies of the Options.
+	circles houghcircle.Table
+}
+
+// profiles are named Options presets for common deployments.
+var profiles = map[string]Options{
+	"default": {},
+	// embedded is for Raspberry Pi or Jetson class acquisition
+	// devices. Working at 240 rows keeps the edge maps and the
+	// refinement pass cheap enough for a few frames per second,
+	// and 240 rows is still plenty for the coarse pass to have a
+	// thumbnail to work with.
+	"embedded": {MaxImageHeight: 240},
+}
+
+// Profile returns the Options preset with the given name.
+func Profile(name string) (Options, error) {
+	opts, ok := profiles[name]
+	if !ok {
+		return Options{}, fmt.Errorf("unknown profile %q", name)
+	}
+	return opts, nil
+}
+
+// houghOptions returns the houghcircle Options for full circle
+// searches with o's settings.
+func (o *Options) houghOptions() *houghcircle.Options {
+	tab := o.circles
+	if tab == nil {
+		tab = circlePoints
+	}
+	return &houghcircle.Options{
+		NoSmoothing: o.NoAccumulatorSmoothing,
+		Workers:     o.Parallelism,
+		Table:       tab,
+	}
+}
+
+// pupilRadii returns the range of pupil radii, in pixels, to search
+// for in an image with the given number of rows.
+func (o *Options) pupilRadii(rows int) (min, max float64) {
+	mult := math.Max(1, float64(rows)/coarseHeight)
+	min, max = minPupilRadius*mult, maxPupilRadius*mult
+	if o.MinPupilRadius > 0 {
+		min = float64(o.MinPupilRadius)
+	}
+	if o.MaxPupilRadius > 0 {
+		max = float64(o.MaxPupilRadius)
+	}
+	return min, max
+}
+
+// edgeGapKernel returns the size of the kernel that closes gaps in
+// the edge map of an image with the given number of rows: an eighth
+// of the smallest expected pupil radius, and odd.
+func (o *Options) edgeGapKernel(rows int) int {
+	min, _ := o.pupilRadii(rows)
+	return int(math.Max(3, min/8)) | 1
+}
+
+// edgeBlurs returns the Gaussian blur sizes that sparsePupilEdges
+// tries, in order.
+func (o *Options) edgeBlurs() []int {
+	if o.EdgeBlur > 0 {
+		return []int{o.EdgeBlur | 1}
+	}
+	return edgeBlurs
+}
+
+// darkThreshold returns the pixel value at or below which edgeMap1
+// takes pixels of src to be dark.
+func (o *Options) darkThreshold(src gocv.Mat) float32 {
+	if o.DarkFraction <= 0 {
+		return darkLevel
+	}
+	return float32(percentile(src.ToBytes(), o.DarkFraction))
+}
+
+// openKernel returns the size of the kernel that edgeMap1 opens the
+// dark regions with.
+func (o *Options) openKernel() int {
+	if o.OpenKernel > 0 {
+		return o.OpenKernel
+	}
+	return defaultOpenKernel
+}
+
+// coarseHeight returns the thumbnail height to use for a coarse pupil
+// search in an image with the given number of rows. The thumbnail is
+// as small as possible, while keeping the smallest expected pupil at
+// least minPupilRadius pixels across.
+func (o *Options) coarseHeight(rows int) int {
+	min, _ := o.pupilRadii(rows)
+	return int(math.Ceil(float64(rows) * minPupilRadius / min))
+}
+
+// coarseRadii returns the range of radii, in thumbnail pixels, that
+// a coarse pupil search should consider, given an image with the
+// given number of rows that got shrunk by a factor of mult.
+func (o *Options) coarseRadii(rows int, mult float64) (min, max int) {
+	lo, hi := o.pupilRadii(rows)
+	// Circles smaller than 2 pixels are indistinguishable from
+	// noise.
+	min = int(math.Max(2, math.Floor(lo/mult)))
+	max = int(math.Ceil(hi / mult))
+	if max <= min {
+		max = min + 1
+	}
+	return min, max
+}
+
+// A Fitter is an algorithm that finds the pupil circle. Most fitters
+// work on the pupil edge map, but some work on the image directly.
+type Fitter int
+
+const (
+	// FitHough uses a circular Hough transform, first on a
+	// thumbnail and then refined on the full image.
+	FitHough Fitter = iota
+	// FitRANSAC fits circles to random triplets of edge points, and
+	// keeps the one with the most supporting edge pixels.
+	FitRANSAC
+	// FitRadialSymmetry uses the fast radial symmetry transform on
+	// image gradients. It is the cheapest fitter, meant for
+	// tracking pupils in video, but also the least precise.
+	FitRadialSymmetry
+	// FitCNN segments the image with a neural network, and fits a
+	// circle to the pupil mask. It copes with visible light and
+	// off-angle images that defeat the edge-based fitters, but needs
+	// Options.ModelPath and is by far the slowest.
+	FitCNN
+)
+
+// fitterNames maps Fitters to their user-facing names.
+var fitterNames = map[Fitter]string{
+	FitHough:          "hough",
+	FitRANSAC:         "ransac",
+	FitRadialSymmetry: "frst",
+	FitCNN:            "cnn",
+}
+
+func (f Fitter) String() string {
+	if s, ok := fitterNames[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("Fitter(%d)", int(f))
+}
+
+// ParseFitter returns the Fitter with the given name.
+func ParseFitter(s string) (Fitter, error) {
+	for f, name := range fitterNames {
+		if name == s {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown circle fitter %q", s)
+}
+
+// An EdgeCombination is a way of merging the two intermediate edge
+// maps that the pupil edge map is built from. Each map holds the
+// pupil boundary plus different kinds of noise, so keeping only what
+// both agree on leaves mostly the pupil.
+type EdgeCombination int
+
+const (
+	// CombineAnd keeps pixels that are edges in both maps, however
+	// faint. Pupil edge pixels that one map barely missed are lost.
+	CombineAnd EdgeCombination = iota
+	// CombineProduct multiplies edge strengths, and keeps pixels
+	// whose product is high enough. A strong edge in one map makes
+	// up for a weak one in the other.
+	CombineProduct
+	// CombineMin keeps pixels whose weaker edge is strong enough.
+	// It is stricter than CombineProduct about one sided edges.
+	CombineMin
+)
+
+// edgeCombinationNames maps EdgeCombinations to their user-facing
+// names.
+var edgeCombinationNames = map[EdgeCombination]string{
+	CombineAnd:     "and",
+	CombineProduct: "product",
+	CombineMin:     "min",
+}
+
+func (c EdgeCombination) String() string {
+	if s, ok := edgeCombinationNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("EdgeCombination(%d)", int(c))
+}
+
+// ParseEdgeCombination returns the EdgeCombination with the given
+// name.
+func ParseEdgeCombination(s string) (EdgeCombination, error) {
+	for c, name := range edgeCombinationNames {
+		if name == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown edge combination %q", s)
+}
+
+// A Retry is an alternate set of parameters to localize with, when
+// the configured ones fail. Each is Options with one change, aimed at
+// a common cause of failure.
+type Retry int
+
+const (
+	// RetryNone is the configured Options, unchanged. It only
+	// appears in results, to mark attempts that weren't retries.
+	RetryNone Retry = iota
+	// RetryWiderRadii halves the smallest expected pupil radius, and
+	// doubles the largest, for cameras at unexpected distances.
+	RetryWiderRadii
+	// RetryCloseEdges sets CloseEdgeGaps, for patchy pupil edges.
+	RetryCloseEdges
+	// RetrySoftEdges uses CombineProduct, which keeps faint pupil
+	// edges that CombineAnd thresholds away, for low contrast images.
+	RetrySoftEdges
+	// RetryCNN switches to FitCNN. It's skipped without a ModelPath.
+	RetryCNN
+)
+
+// retryNames maps Retries to their user-facing names.
+var retryNames = map[Retry]string{
+	RetryNone:       "none",
+	RetryWiderRadii: "wider-radii",
+	RetryCloseEdges: "close-edges",
+	RetrySoftEdges:  "soft-edges",
+	RetryCNN:        "cnn",
+}
+
+func (r Retry) String() string {
+	if s, ok := retryNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Retry(%d)", int(r))
+}
+
+// ParseRetry returns the Retry with the given name. RetryNone isn't
+// a retry, and can't be parsed.
+func ParseRetry(s string) (Retry, error) {
+	for r, name := range retryNames {
+		if name == s && r != RetryNone {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown retry %q", s)
+}
+
+// apply returns o changed as r says, for an image with the given
+// number of rows.
+func (r Retry) apply(o Options, rows int) Options {
+	switch r {
+	case RetryWiderRadii:
+		min, max := o.pupilRadii(rows)
+		o.MinPupilRadius = int(math.Max(1, min/2))
+		o.MaxPupilRadius = int(math.Ceil(max * 2))
+	case RetryCloseEdges:
+		o.CloseEdgeGaps = true
+	case RetrySoftEdges:
+		o.EdgeCombination = CombineProduct
+	case RetryCNN:
+		o.Fitter = FitCNN
+	}
+	return o
+}
+
+// An Accelerator is a kind of hardware that can run neural networks.
+type Accelerator int
+
+const (
+	// AccelCPU runs on the CPU, and is always available.
+	AccelCPU Accelerator = iota
+	// AccelOpenCL runs on an OpenCL device, typically a GPU.
+	AccelOpenCL
+	// AccelCUDA runs on an NVIDIA GPU through CUDA.
+	AccelCUDA
+)
+
+// acceleratorNames maps Accelerators to their user-facing names.
+var acceleratorNames = map[Accelerator]string{
+	AccelCPU:    "cpu",
+	AccelOpenCL: "opencl",
+	AccelCUDA:   "cuda",
+}
+
+func (a Accelerator) String() string {
+	if s, ok := acceleratorNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("Accelerator(%d)", int(a))
+}
+
+// ParseAccelerator returns the Accelerator with the given name.
+func ParseAccelerator(s string) (Accelerator, error) {
+	for a, name := range acceleratorNames {
+		if name == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown accelerator %q", s)
+}
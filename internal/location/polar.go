@@ -0,0 +1,118 @@
+package location
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+// polarImage is an annulus of an image, unwrapped into polar
+// coordinates around a center point. Boundaries that are roughly
+// concentric with the center, like the limbus around the pupil,
+// become roughly horizontal lines, which are much easier to follow
+// than circles.
+type polarImage struct {
+	center image.Point
+	// minR is the radius of the annulus' inner edge.
+	minR int
+	// at[deg][i] is the brightness at deg degrees and radius
+	// minR+i. Samples beyond the image's edge repeat the nearest
+	// pixel inside it.
+	at [360][]float64
+}
+
+// unwrapPolar samples im in the annulus between minR and maxR around
+// center, at one degree and one pixel steps.
+func unwrapPolar(im gocv.Mat, center image.Point, minR, maxR int) *polarImage {
+	b := pixel.Bounds(im)
+	ret := &polarImage{center: center, minR: minR}
+	for deg := range ret.at {
+		rad := float64(deg) * math.Pi / 180.0
+		dx, dy := math.Cos(rad), math.Sin(rad)
+		ret.at[deg] = make([]float64, maxR-minR+1)
+		for i := range ret.at[deg] {
+			r := float64(minR + i)
+			p := center.Add(image.Point{
+				X: int(math.Round(r * dx)),
+				Y: int(math.Round(r * dy)),
+			})
+			p.X = max(b.Min.X, min(p.X, b.Max.X-1))
+			p.Y = max(b.Min.Y, min(p.Y, b.Max.Y-1))
+			ret.at[deg][i] = float64(pixel.UCharAt(im, p))
+		}
+	}
+	return ret
+}
+
+// radius returns the radius of sample i.
+func (p *polarImage) radius(i int) float64 {
+	return float64(p.minR + i)
+}
+
+// radialEdges returns, for every sample, how much brighter the width
+// samples just outside it are than the width samples just inside it.
+// Dark to bright boundaries going outwards, like the limbus, score
+// positive, and bright to dark ones negative. Samples too close to
+// either edge of the annulus to compare score zero.
+func (p *polarImage) radialEdges(width int) [360][]float64 {
+	var ret [360][]float64
+	for deg, ray := range p.at {
+		ret[deg] = make([]float64, len(ray))
+		for i := width; i+width < len(ray); i++ {
+			var in, out float64
+			for j := 1; j <= width; j++ {
+				in += ray[i-j]
+				out += ray[i+j]
+			}
+			ret[deg][i] = (out - in) / float64(width)
+		}
+	}
+	return ret
+}
+
+// bestPath finds the path through score, one sample per row (usually
+// a degree), with the highest total score, subject to the sample
+// index changing by at most maxStep between adjacent rows. All rows
+// must be the same length. It's a dynamic programming search, so the
+// result is the global optimum, and the step limit keeps it smooth
+// without assuming any particular shape.
+//
+// For a full circle of rows, the path isn't forced to close, so the
+// first and last rows may differ by more than maxStep. Callers should
+// put the seam where the boundary is clearest.
+func bestPath(score [][]float64, maxStep int) []int {
+	n := len(score[0])
+	total := make([]float64, n)
+	copy(total, score[0])
+	from := make([][]int, len(score))
+	for deg := 1; deg < len(score); deg++ {
+		next := make([]float64, n)
+		from[deg] = make([]int, n)
+		for i := 0; i < n; i++ {
+			best := math.Inf(-1)
+			for j := max(0, i-maxStep); j <= min(n-1, i+maxStep); j++ {
+				if total[j] > best {
+					best = total[j]
+					from[deg][i] = j
+				}
+			}
+			next[i] = best + score[deg][i]
+		}
+		total = next
+	}
+
+	last := len(score) - 1
+	ret := make([]int, len(score))
+	for i := range total {
+		if total[i] > total[ret[last]] {
+			ret[last] = i
+		}
+	}
+	for deg := last; deg > 0; deg-- {
+		ret[deg-1] = from[deg][ret[deg]]
+	}
+	return ret
+}
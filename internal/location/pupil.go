@@ -1,13 +1,17 @@
 package location
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"math"
-	"time"
 
 	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+	"go.universe.tf/iris/internal/geometry/houghcircle"
+	"go.universe.tf/iris/internal/geometry/pixel"
 )
 
 type Circle struct {
@@ -19,12 +23,381 @@ func (p Circle) String() string {
 	return fmt.Sprintf("(%d,%d,%d)", p.X, p.Y, p.R)
 }
 
-// FindPupil locates a single pupil in the provided image, and returns it.
-func FindPupil(im gocv.Mat) (Circle, Circle) {
+// Normalized returns p in coordinates relative to an image of size
+// b: X is a fraction of the image width, Y and R of its height, all
+// counted from b.Min. Unlike pixels, these survive resizing the
+// image, as long as its aspect ratio is preserved.
+func (p Circle) Normalized(b image.Rectangle) NormalizedCircle {
+	w, h := float64(b.Dx()), float64(b.Dy())
+	return NormalizedCircle{
+		X: float64(p.X-b.Min.X) / w,
+		Y: float64(p.Y-b.Min.Y) / h,
+		R: float64(p.R) / h,
+	}
+}
+
+// NormalizedCircle is a circle in normalized image coordinates, see
+// Circle.Normalized.
+type NormalizedCircle struct {
+	X, Y, R float64
+}
+
+func (p NormalizedCircle) String() string {
+	return fmt.Sprintf("(%.4f,%.4f,%.4f)", p.X, p.Y, p.R)
+}
+
+// scale returns p scaled up by sx horizontally and sy vertically.
+// The radius is scaled by the average of the two.
+func (p Circle) scale(sx, sy float64) Circle {
+	return Circle{
+		Point: image.Point{
+			X: int(math.Round(float64(p.X) * sx)),
+			Y: int(math.Round(float64(p.Y) * sy)),
+		},
+		R: int(math.Round(float64(p.R) * (sx + sy) / 2)),
+	}
+}
+
+// FindPupil locates a single pupil in the provided image, and returns
+// it. opts may be nil, in which case defaults are used.
+func FindPupil(im gocv.Mat, opts *Options) Circle {
+	return LocatePupil(im, opts).Pupil
+}
+
+// PupilResult is a pupil located by LocatePupil.
+type PupilResult struct {
+	// Pupil is the pupil circle returned by FindPupil.
+	Pupil Circle
+	// Diagnostics describes how the fitter arrived at Pupil.
+	Diagnostics Diagnostics
+	// Fitter is the fitter that produced the result.
+	Fitter Fitter
+	// Confidence is how well the image supports Pupil being the
+	// pupil boundary, between 0 and 1. It is computed the same way
+	// regardless of Fitter, so it can be compared across fitters.
+	Confidence float64
+	// Confident is true if a pupil was found, with a Confidence of
+	// at least Options.MinConfidence.
+	Confident bool
+	// Fallback is true if Fitter is one of Options.Fallback, because
+	// Options.Fitter wasn't confident enough.
+	Fallback bool
+	// Retry is the alternate parameter set from Options.Retries
+	// that produced the result, or RetryNone.
+	Retry Retry
+	// Degraded is true if the refinement stage failed to produce a
+	// trustworthy circle. Pupil is then the coarse estimate, which
+	// is less precise but still sound.
+	Degraded bool
+	// Accelerator is the hardware the CNN ran on, if Fitter is
+	// FitCNN.
+	Accelerator Accelerator
+	// Reused is true if Tracker judged that the frame hadn't
+	// changed, and returned its previous result without localizing
+	// again.
+	Reused bool
+	// Gaze is the estimated gaze direction, if Options.EstimateGaze
+	// was set and the pupil outline allowed for an estimate.
+	Gaze *Gaze
+	// Contour is the traced pupil boundary, if Options.TraceContour
+	// was set and a pupil was found.
+	Contour *Contour
+	// Fingerprint identifies the pipeline configuration that
+	// produced the result, see Options.Fingerprint.
+	Fingerprint string
+	// Reasons are the likely causes, if no pupil was found or
+	// Confidence is below Options.MinConfidence. It is empty
+	// otherwise, and may also be empty if no known cause applies.
+	Reasons []Reason
+}
+
+// Diagnostics are the intermediate results of localizing a pupil.
+// Most callers only need PupilResult.Pupil, these are for debugging
+// and tuning the fitters.
+type Diagnostics struct {
+	// Coarse is the fitter's first estimate of the pupil, before
+	// refinement.
+	Coarse Circle
+	// CoarseVotes and RefinedVotes are the number of edge pixels
+	// that supported Coarse in the thumbnail, and the final pupil in
+	// the full image. Only the Hough fitter votes, for the others
+	// they are zero.
+	CoarseVotes  int
+	RefinedVotes int
+	// Shift and Grow are how much refinement moved Coarse's center,
+	// and changed its radius, to arrive at the final pupil.
+	Shift image.Point
+	Grow  int
+	// EdgeDensity is the fraction of the edge map's pixels that were
+	// candidate edge points, and EdgeBlur the size of the blur
+	// applied before edge detection. Noisy images get blurred more
+	// than usual, until their edge map is sparse enough. Fitters
+	// that don't use the edge map leave both zero.
+	EdgeDensity float64
+	EdgeBlur    int
+	// Attempts are all the fits LocatePupil tried, in order, the
+	// winning one included.
+	Attempts []Attempt
+}
+
+// An Attempt is one fit tried by LocatePupil.
+type Attempt struct {
+	Fitter     Fitter
+	Fallback   bool
+	Retry      Retry
+	Pupil      Circle
+	Confidence float64
+}
+
+// diagnose returns the Diagnostics of refining coarse into pupil.
+func diagnose(coarse, pupil Circle, coarseVotes, refinedVotes int) Diagnostics {
+	return Diagnostics{
+		Coarse:       coarse,
+		CoarseVotes:  coarseVotes,
+		RefinedVotes: refinedVotes,
+		Shift:        pupil.Point.Sub(coarse.Point),
+		Grow:         pupil.R - coarse.R,
+	}
+}
+
+// LocatePupil is like FindPupil, but also reports which fitter found
+// the pupil, and how confident it is.
+//
+// Options.Fitter runs first. If its result's confidence is below
+// Options.MinConfidence, the fitters in Options.Fallback are tried in
+// order until one is confident enough. If none are, the most
+// confident result wins.
+func LocatePupil(im gocv.Mat, opts *Options) PupilResult {
+	// Background is never done, so there's no error to report.
+	r, _ := LocatePupilContext(context.Background(), im, opts)
+	return r
+}
+
+// LocatePupilContext is like LocatePupil, but gives up as soon as
+// possible once ctx is done, and returns ctx's error. This bounds the
+// time spent on huge or noisy images, which can take far longer than
+// usual to localize.
+//
+// Cancellation is checked between stages and inside the voting loops.
+// A single OpenCV call, such as running the CNN, can't be
+// interrupted, and runs to completion before the deadline is noticed.
+func LocatePupilContext(ctx context.Context, im gocv.Mat, opts *Options) (PupilResult, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// Full resolution is a luxury on slow hardware. If asked,
+	// localize on a smaller copy of the image, and scale the result
+	// back up.
+	if opts.MaxImageHeight > 0 && pixel.Bounds(im).Dy() > opts.MaxImageHeight {
+		small, mult := shrink(im, opts.MaxImageHeight)
+		defer small.Close()
+		smallOpts := *opts
+		smallOpts.MaxImageHeight = 0
+		// The contour is traced on the full image, below.
+		smallOpts.TraceContour = false
+		// A zero minimum means the default, so a set one must not
+		// shrink to it.
+		if opts.MinPupilRadius > 0 {
+			smallOpts.MinPupilRadius = int(math.Max(1, math.Floor(float64(opts.MinPupilRadius)/mult)))
+		}
+		smallOpts.MaxPupilRadius = int(math.Ceil(float64(opts.MaxPupilRadius) / mult))
+		r, err := LocatePupilContext(ctx, small, &smallOpts)
+		if err != nil {
+			return PupilResult{}, err
+		}
+		sx, sy := axisScales(im, small)
+		r.Pupil = r.Pupil.scale(sx, sy)
+		d := diagnose(r.Diagnostics.Coarse.scale(sx, sy), r.Pupil, r.Diagnostics.CoarseVotes, r.Diagnostics.RefinedVotes)
+		r.Diagnostics.Coarse, r.Diagnostics.Shift, r.Diagnostics.Grow = d.Coarse, d.Shift, d.Grow
+		for i := range r.Diagnostics.Attempts {
+			a := &r.Diagnostics.Attempts[i]
+			a.Pupil = a.Pupil.scale(sx, sy)
+		}
+		if opts.TraceContour && r.Pupil.R > 0 {
+			c := FindPupilContour(im, r.Pupil, opts)
+			r.Contour = &c
+		}
+		r.Fingerprint = opts.Fingerprint()
+		return r, nil
+	}
+
+	minConfidence := opts.MinConfidence
+	if minConfidence == 0 {
+		minConfidence = defaultMinConfidence
+	}
+
+	// The configured fitter goes first, then the fallback fitters,
+	// then the retries, until one is confident enough.
+	type try struct {
+		attempt Attempt
+		opts    *Options
+	}
+	var tries []try
+	for i, f := range append([]Fitter{opts.Fitter}, opts.Fallback...) {
+		tries = append(tries, try{Attempt{Fitter: f, Fallback: i > 0}, opts})
+	}
+	for _, rt := range opts.Retries {
+		if rt == RetryCNN && opts.ModelPath == "" {
+			continue
+		}
+		o := rt.apply(*opts, pixel.Bounds(im).Dy())
+		tries = append(tries, try{Attempt{Fitter: o.Fitter, Retry: rt}, &o})
+	}
+
+	var (
+		best     PupilResult
+		attempts []Attempt
+	)
+	for i, t := range tries {
+		pupil, diag, refineOK := fitPupil(ctx, im, t.attempt.Fitter, t.opts)
+		// A fitter interrupted by ctx returns whatever it had so
+		// far, which is meaningless.
+		if err := ctx.Err(); err != nil {
+			return PupilResult{}, errs.FromContext(err)
+		}
+		r := PupilResult{
+			Pupil:       pupil,
+			Diagnostics: diag,
+			Fitter:      t.attempt.Fitter,
+			Confidence:  pupilConfidence(im, pupil),
+			Fallback:    t.attempt.Fallback,
+			Retry:       t.attempt.Retry,
+			Degraded:    !refineOK,
+		}
+		if r.Fitter == FitCNN {
+			r.Accelerator = opts.Accelerator
+		}
+		t.attempt.Pupil, t.attempt.Confidence = r.Pupil, r.Confidence
+		attempts = append(attempts, t.attempt)
+		if i == 0 || r.Confidence > best.Confidence {
+			best = r
+		}
+		if best.Confidence >= minConfidence {
+			break
+		}
+	}
+	best.Diagnostics.Attempts = attempts
+
+	best.Confident = best.Pupil.R > 0 && best.Confidence >= minConfidence
+	if !best.Confident {
+		best.Reasons = explain(im, best, opts)
+	}
+
+	if opts.EstimateGaze && best.Pupil.R > 0 {
+		if e, err := FindPupilEllipse(im, best.Pupil.Point); err == nil {
+			if g, ok := EstimateGaze(e); ok {
+				if l, err := FindLimbus(im, best.Pupil); err == nil && l.Contrast >= MinLimbusContrast {
+					g = g.Orient(e, l.Circle)
+				}
+				best.Gaze = &g
+			}
+		}
+	}
+
+	if opts.TraceContour && best.Pupil.R > 0 {
+		c := FindPupilContour(im, best.Pupil, opts)
+		best.Contour = &c
+	}
+
+	best.Fingerprint = opts.Fingerprint()
+	return best, nil
+}
+
+// fitPupil locates the pupil in im using fitter f.
+// refineOK is false if refinement failed, and pupil is just the
+// coarse estimate.
+func fitPupil(ctx context.Context, im gocv.Mat, f Fitter, opts *Options) (pupil Circle, diag Diagnostics, refineOK bool) {
+	// The radial symmetry transform and the CNN work directly on
+	// the image, and don't need any of the edge map machinery.
+	switch f {
+	case FitRadialSymmetry:
+		approx, refined := radialSymmetry(im, opts)
+		return refined, diagnose(approx, refined, 0, 0), true
+	case FitCNN:
+		approx, refined := segmentPupil(im, opts)
+		return refined, diagnose(approx, refined, 0, 0), true
+	}
+
 	// This is the algorithm from "Accurate Iris Localization Using
 	// Edge Map Generation and Adaptive Circular Hough Transform for
 	// Less Constrained Iris Images", by Kumar, Asati and Gupta.
+	edge, prior, ok, blur, density := pupilEdgeMap(im, opts)
+	switch f {
+	case FitRANSAC:
+		approx, refined := fitRANSAC(ctx, edge, opts)
+		pupil, diag, refineOK = refined, diagnose(approx, refined, 0, 0), true
+	default:
+		if !ok {
+			pupil, diag, refineOK = findBestCircle(ctx, edge, nil, opts)
+		} else {
+			pupil, diag, refineOK = findBestCircle(ctx, edge, &prior, opts)
+		}
+	}
+	diag.EdgeBlur, diag.EdgeDensity = blur, density
+	return pupil, diag, refineOK
+}
 
+// pupilEdgeMap returns the pupil edge map of im that the edge based
+// fitters work on, as configured by opts, along with what
+// sparsePupilEdges found making it.
+func pupilEdgeMap(im gocv.Mat, opts *Options) (edge gocv.Mat, prior image.Point, ok bool, blur int, density float64) {
+	edge, prior, ok, blur, density = sparsePupilEdges(im, opts)
+	if opts.CloseEdgeGaps {
+		k := opts.edgeGapKernel(pixel.Bounds(im).Dy())
+		kernel := gocv.GetStructuringElement(gocv.MorphEllipse, image.Point{k, k})
+		gocv.MorphologyEx(edge, &edge, gocv.MorphClose, kernel)
+		kernel.Close()
+	}
+	if opts.ThinEdges {
+		thin := thinEdges(edge, im, blur)
+		edge.Close()
+		edge = thin
+	}
+	return edge, prior, ok, blur, density
+}
+
+// maxEdgeDensity is the fraction of edge map pixels above which the
+// map is too noisy to vote on. A clean map holds little more than the
+// pupil boundary, a few percent of the image at most. Much denser,
+// and the Hough transform both slows down and finds peaks in noise
+// that move around from frame to frame.
+const maxEdgeDensity = 0.1
+
+// edgeBlurs are the Gaussian blur sizes that sparsePupilEdges tries
+// by default, in order. The first is the traditional 5x5.
+var edgeBlurs = []int{5, 9, 13}
+
+// sparsePupilEdges is pupilEdges, retrying with stronger blurs while
+// the edge map is denser than maxEdgeDensity, unless opts fixes the
+// blur. It also returns the blur size it settled on, and the final
+// edge map's density. If even the strongest blur leaves a dense map,
+// that map is returned anyway.
+func sparsePupilEdges(im gocv.Mat, opts *Options) (edge gocv.Mat, prior image.Point, ok bool, blur int, density float64) {
+	b := pixel.Bounds(im)
+	area := float64(b.Dx() * b.Dy())
+	blurs := opts.edgeBlurs()
+	for i := range blurs {
+		blur = blurs[i]
+		edge, prior, ok = pupilEdges(im, blur, opts)
+		density = float64(gocv.CountNonZero(edge)) / area
+		if density <= maxEdgeDensity || i == len(blurs)-1 {
+			break
+		}
+		edge.Close()
+	}
+	return edge, prior, ok, blur, density
+}
+
+// pupilEdges computes an edge map of im that should contain little
+// more than the pupil boundary, after blurring im with a blurSize
+// Gaussian kernel. Its two intermediate edge maps are merged as
+// opts.EdgeCombination says.
+//
+// It also returns the centroid of the largest dark region in im,
+// which is a good first guess for the pupil center. ok is false if
+// there are no dark regions.
+func pupilEdges(im gocv.Mat, blurSize int, opts *Options) (edge gocv.Mat, prior image.Point, ok bool) {
 	// For our edgeMap1, we're assuming that the pupil will be one of
 	// the darkest things in the image. Poor quality images can have a
 	// "brightness floor" that's too high. To compensate for that, we
@@ -37,9 +410,10 @@ func FindPupil(im gocv.Mat) (Circle, Circle) {
 	gocv.Normalize(im, &norm, 255.0, 0.0, gocv.NormMinMax)
 
 	// Edge detection just works better if you filter out
-	// high-frequency noise. A 5x5 Gaussian blur is traditional.
+	// high-frequency noise. A 5x5 Gaussian blur is traditional,
+	// noisier images need more.
 	blur := gocv.NewMat()
-	gocv.GaussianBlur(norm, &blur, image.Point{5, 5}, 0, 0, gocv.BorderDefault)
+	gocv.GaussianBlur(norm, &blur, image.Point{blurSize, blurSize}, 0, 0, gocv.BorderDefault)
 
 	// Compute our two edge maps. See the functions for details of
 	// what they do, but the short version is that they should both
@@ -49,213 +423,310 @@ func FindPupil(im gocv.Mat) (Circle, Circle) {
 	// em1 will return false edges around non-pupil dark patches in
 	// the image, whereas em2 will return false edges around
 	// reflections, eyelids and eyelashes.
-	em1 := edgeMap1(blur)
+	em1, mask := edgeMap1(blur, opts)
 	em2 := edgeMap2(blur)
+	prior, ok = darkCentroid(mask)
 
 	// We now have two edge maps, which mostly only have the pupil
-	// edge in common. ANDing them together removes everything else,
-	// and leaves us with (hopefully) just a nice clean circle to
-	// apply circle detection on!
-	edge := gocv.NewMat()
-	gocv.BitwiseAnd(em1, em2, &edge)
-	return findBestCircle(edge)
+	// edge in common. Keeping only what they agree on removes
+	// everything else, and leaves us with (hopefully) just a nice
+	// clean circle to apply circle detection on!
+	edge = combineEdges(em1, em2, opts.EdgeCombination)
+	return edge, prior, ok
 }
 
-// circlePoints lists (x,y) coordinates for pixels on a circle of a
-// given radius.
-var circlePoints map[int][]image.Point
-
-func init() {
-	circlePoints = map[int][]image.Point{}
-	for r := 5; r < 15; r++ {
-		circlePoints[r] = calcCirclePoints(r)
-	}
-}
+// softEdgeStrength is the edge strength, out of 255, that both edge
+// maps need to roughly agree on for the soft combinations to keep a
+// pixel.
+const softEdgeStrength = 64
 
-// circlePoints computes the (x,y) coordinates for pixels on a circle
-// of a given radius.
-func calcCirclePoints(r int) []image.Point {
-	var (
-		ret  []image.Point
-		last image.Point
-	)
-	for i := 0; i < 360; i++ {
-		x := int(float64(r) * math.Cos(float64(i)*math.Pi/180.0))
-		y := int(float64(r) * math.Sin(float64(i)*math.Pi/180.0))
-		p := image.Point{x, y}
-		if p != last {
-			ret = append(ret, p)
-			last = p
-		}
+// combineEdges merges the edge maps em1 and em2 as selected by
+// combine. The soft combinations return a binary map.
+func combineEdges(em1, em2 gocv.Mat, combine EdgeCombination) gocv.Mat {
+	ret := gocv.NewMat()
+	switch combine {
+	case CombineProduct:
+		// The product of two 8-bit maps overflows 8 bits, so
+		// multiply in floating point, and threshold there.
+		f1, f2 := gocv.NewMat(), gocv.NewMat()
+		defer f1.Close()
+		defer f2.Close()
+		em1.ConvertTo(&f1, gocv.MatTypeCV32F)
+		em2.ConvertTo(&f2, gocv.MatTypeCV32F)
+		gocv.Multiply(f1, f2, &f1)
+		gocv.Threshold(f1, &f1, softEdgeStrength*softEdgeStrength, 255, gocv.ThresholdBinary)
+		f1.ConvertTo(&ret, gocv.MatTypeCV8U)
+	case CombineMin:
+		gocv.Min(em1, em2, &ret)
+		gocv.Threshold(ret, &ret, softEdgeStrength, 255, gocv.ThresholdBinary)
+	default:
+		gocv.BitwiseAnd(em1, em2, &ret)
 	}
 	return ret
 }
 
-// findBestCircle finds the single best defined circle in im.
+const (
+	// coarseHeight is the height, in pixels, of the thumbnail used
+	// for the coarse circle search, when Options doesn't specify
+	// the expected pupil size.
+	coarseHeight = 60
+	// minPupilRadius and maxPupilRadius bound the radii, in
+	// thumbnail pixels, that the coarse search considers by
+	// default.
+	minPupilRadius = 5
+	maxPupilRadius = 15
+)
+
+// circlePoints holds the rasterizations of the coarse search's
+// default radii, for when Options has no precomputed table.
+var circlePoints = houghcircle.NewTable(minPupilRadius, maxPupilRadius)
+
+// findBestCircle finds the single best defined circle in im. It
+// returns the refined circle, and diagnostics that include the
+// approximate circle from the coarse search.
 //
 // Input pixels should be zero for non-candidate points, any other
 // value is assumed to be a point on the circle we're looking for.
-func findBestCircle(im gocv.Mat) (Circle, Circle) {
-	st := time.Now()
+//
+// If prior is non-nil, it is a guess at the circle's center, and
+// candidate centers near it are favored over ones far away.
+//
+// If refinement fails to find a well supported circle, the
+// approximate circle is returned, and refineOK is false.
+func findBestCircle(ctx context.Context, im gocv.Mat, prior *image.Point, opts *Options) (circle Circle, diag Diagnostics, refineOK bool) {
+	approximate, mult := coarseCircle(ctx, im, prior, opts)
+	approxCircle := Circle{approximate.Point, approximate.R}
+
+	// If `mult` was one, we didn't resize the image at all, so our
+	// approximate guess is actually just the correct guess, and we
+	// can return that.
+	if mult == 1 {
+		return approxCircle, diagnose(approxCircle, approxCircle, approximate.Votes, approximate.Votes), true
+	}
+
+	// `approximate` is the circle that had the most number of
+	// supportive pixels in a small version of our image. Scaled back
+	// up it's reasonably good, but could be over `mult` pixels out,
+	// on both center and radius.
+	//
+	// so, let's do another round of hough circle detection, this time
+	// on the full image... But now, we'll only look at radii and
+	// centers that are "near" our approximation, to cut down
+	// drastically on memory and CPU cost.
+	best := houghcircle.Refine(ctx, im, approximate, refineWindow(mult), opts.houghOptions())
+
+	// The full resolution edge map can be much sparser than the
+	// thumbnail suggests, e.g. when the pupil edge is blurry and
+	// only survived downscaling as a smear. Then the refinement's
+	// winner is whatever few stray pixels happened to line up, far
+	// worse than the approximation.
+	if best.R == 0 || float64(best.Votes) < minRefineSupport*float64(len(houghcircle.Points(best.R))) {
+		return approxCircle, diagnose(approxCircle, approxCircle, approximate.Votes, 0), false
+	}
+	refined := Circle{best.Point, best.R}
+	return refined, diagnose(approxCircle, refined, approximate.Votes, best.Votes), true
+}
+
+// coarseCircle finds the best defined circle in a thumbnail of the
+// edge map im, and returns it scaled back to im's coordinates, along
+// with the factor im was shrunk by. prior is as for findBestCircle.
+func coarseCircle(ctx context.Context, im gocv.Mat, prior *image.Point, opts *Options) (approximate houghcircle.Candidate, mult float64) {
 	// This algorithm is very expensive in the number of pixels
 	// processed. To work around this, we first run it on a small
 	// version of the image to get an approximate center and
 	// radius. Then we rerun on the larger image with a much smaller
 	// search space, to refine things.
-	small, mult := shrink(im, 60)
-
-	// We don't know the radius of the circle we're looking for, so
-	// we're going to iterate through a set of plausible sizes,
-	// looking for the radius that gives us the strongest match.
 	//
-	// Keep track of the best circle we've found so far.
-	var (
-		winner      Circle
-		winnerVotes int16
-	)
+	// How small we can go depends on how big the pupil is. Shrink
+	// too much, and a small pupil vanishes into a couple of pixels.
+	small, mult := shrink(im, opts.coarseHeight(pixel.Bounds(im).Dy()))
+	defer small.Close()
+	minR, maxR := opts.coarseRadii(pixel.Bounds(im).Dy(), mult)
 
-	for r, circlePoints := range circlePoints {
-		// The circle Hough transform uses a "voting matrix". We make
-		// a variety of guesses as to where the circle center might
-		// be, and this matrix tracks the number of "votes" that each
-		// pixel gets for being the center.
-		votes := gocv.NewMatWithSize(small.Size()[0], small.Size()[1], gocv.MatTypeCV16S)
-
-		for row := 0; row < small.Size()[0]; row++ {
-			for col := 0; col < small.Size()[1]; col++ {
-				// Skip black pixels.
-				if small.GetUCharAt(row, col) == 0 {
-					continue
-				}
+	// We don't know the radius of the circle we're looking for, so
+	// we're going to try a set of plausible sizes, looking for the
+	// radius that gives us the strongest match.
+	var radii []int
+	for r := minR; r < maxR; r++ {
+		radii = append(radii, r)
+	}
 
-				// We think this pixel might be on our circle. If
-				// true, its center would be somewhere on a circle of
-				// radius r and centered here. Add a vote to each of
-				// those locations in the voting matrix.
-				for _, cp := range circlePoints {
-					// (a, b) is our candidate centerpoint.
-					// Annoyingly, image.Point's coordinates are
-					// backwards from OpenCVs: point.X is the column,
-					// point.Y is the row. That's why we seem to be
-					// summing backwards here.
-					a, b := row+cp.Y, col+cp.X
-
-					// Check that (a, b) is in-bounds.
-					if a >= small.Size()[0] ||
-						a < 0 ||
-						b >= small.Size()[1] ||
-						b < 0 {
-						continue
-					}
-
-					// One vote for (a,b) as the center.
-					votes.SetShortAt(a, b, votes.GetShortAt(a, b)+1)
-				}
-			}
+	// The prior is in full size image coordinates, scale it down to
+	// match the thumbnail.
+	var weight *gocv.Mat
+	if prior != nil {
+		smallPrior := image.Point{
+			X: int(float64(prior.X) / mult),
+			Y: int(float64(prior.Y) / mult),
 		}
+		w := priorWeights(smallPrior, pixel.Bounds(small))
+		defer w.Close()
+		weight = &w
+	}
 
-		// The voting matrix is now complete. Time to count, and see
-		// who won.
-		for row := 0; row < small.Size()[0]; row++ {
-			for col := 0; col < small.Size()[1]; col++ {
-				if votes.GetShortAt(row, col) > winnerVotes {
-					// We have a (provisional) winner! Record its
-					// properties. Again, image.Point and gocv
-					// coordinates are reversed from each other,
-					// grumble.
-					winner.X = col
-					winner.Y = row
-					winner.R = r
-					winnerVotes = votes.GetShortAt(row, col)
-				}
-			}
+	ho := opts.houghOptions()
+	ho.Weight = weight
+	var winner houghcircle.Candidate
+	for _, c := range houghcircle.Vote(ctx, small, radii, ho) {
+		if c.Score > winner.Score {
+			winner = c
 		}
 	}
 
-	fmt.Println(time.Since(st))
-	st = time.Now()
-
-	// Having crunched through all possible radii, `winner` is now the
-	// circle that had the most number of supportive pixels,
-	// regardless of radius. We're done!
-	//
-	// Well, not quite. We've just done all this on a small version of
-	// our image. We would just multiply out the coordinates and
-	// radius and it'd be reasonably good, but we could be up to
-	// `mult` pixels out, on both center and radius.
-	//
-	// so, let's do another round of hough circle detection, this time
-	// on the full image... But now, we'll only look at radii and
-	// centers that are "near" our approximation, to cut down
-	// drastically on memory and CPU cost.
-
-	approximate := Circle{
+	// Each thumbnail pixel covers a roughly mult*mult rectangle of
+	// the original image, so map the winner to the middle of its
+	// rectangle rather than its top-left corner.
+	sx, sy := axisScales(im, small)
+	return houghcircle.Candidate{
 		Point: image.Point{
-			X: int(float64(winner.X) * mult),
-			Y: int(float64(winner.Y) * mult),
+			X: int((float64(winner.X) + 0.5) * sx),
+			Y: int((float64(winner.Y) + 0.5) * sy),
 		},
-		R: int(float64(winner.R) * mult),
+		R:     int(math.Round(float64(winner.R) * (sx + sy) / 2)),
+		Votes: winner.Votes,
+		Score: winner.Score,
+	}, mult
+}
+
+// refineWindow returns how far, in pixels, a circle found by
+// coarseCircle in a thumbnail shrunk by mult can be from the true
+// circle, on center and radius.
+//
+// The true center is somewhere in the winning thumbnail pixel, so up
+// to mult/2 away from the approximation. But the resize also blurs
+// edges across neighboring thumbnail pixels, and the voting is on a
+// discrete grid, so the winning cell can be one cell away from the
+// one containing the true center. Same for the radius, which is
+// quantized to whole thumbnail pixels. That makes the uncertainty a
+// full mult in every dimension, plus one more pixel to absorb
+// rounding in the float-to-int conversions.
+func refineWindow(mult float64) int {
+	return int(math.Ceil(mult)) + 1
+}
+
+// minRefineSupport is the fraction of a refined circle's pixels that
+// must be on the edge map for the refinement to be trusted.
+const minRefineSupport = 0.2
+
+// priorWeights returns a matrix covering b of how much to favor each
+// candidate center, given a prior guess at the center. Candidates
+// near the prior get up to twice the weight of those far away, which
+// is enough to break ties between dark regions without overriding
+// clear evidence elsewhere.
+func priorWeights(prior image.Point, b image.Rectangle) gocv.Mat {
+	ret := gocv.NewMatWithSize(b.Dy(), b.Dx(), gocv.MatTypeCV32F)
+	const sigma = maxPupilRadius
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dx, dy := float64(x-prior.X), float64(y-prior.Y)
+			setFloatAt(&ret, image.Point{x, y}, float32(1+math.Exp(-(dx*dx+dy*dy)/(2*sigma*sigma))))
+		}
 	}
+	return ret
+}
 
-	// `mult` tells us how much bigger the original image was. Divide
-	// by two, round up, that gives us the plus/minus count on center
-	// and radius offsets.
-	//
-	// If `mult` was one, we didn't resize the image at all, so our
-	// approximate guess is actually just the correct guess, and we
-	// can return that.
-	if mult == 1 {
-		return approximate, approximate
-	}
-	uncertainty := int(math.Ceil(mult / 2))
-
-	// We now know a cube of (uncertainty, uncertainty, uncertainty)
-	// for where the circle (x, y, r) is. That's a pretty small grid
-	// even on a very large image, so we can just search it
-	// exhaustively, and pick the position that results in the most
-	// non-zero pixels on the resulting circle.
-	winner = Circle{}
-	winnerVotes = 0
-
-	for r := approximate.R - uncertainty; r < approximate.R+uncertainty; r++ {
-		circlePoints := calcCirclePoints(r)
-		for row := approximate.Y - uncertainty; row <= approximate.Y+uncertainty; row++ {
-			for col := approximate.X - uncertainty; col <= approximate.X+uncertainty; col++ {
-				var votes int16
-				for _, cp := range circlePoints {
-					a, b := row+cp.Y, col+cp.X
-					if im.GetUCharAt(a, b) != 0 {
-						votes++
-					}
-				}
-				if votes > winnerVotes {
-					winner.X = col
-					winner.Y = row
-					winner.R = r
-					winnerVotes = votes
-				}
-			}
+// maxDarkElongation is the largest length to width ratio of a dark
+// region that dropElongated keeps. A pupil is round, and even a half
+// closed eyelid leaves it less than 3 times wider than tall.
+const maxDarkElongation = 3
+
+// dropElongated whitens the dark regions of mask, a black-and-white
+// image, that are more than maxDarkElongation times longer than they
+// are wide, in any orientation.
+func dropElongated(mask *gocv.Mat) {
+	inv := gocv.NewMat()
+	defer inv.Close()
+	gocv.BitwiseNot(*mask, &inv)
+
+	var drop [][]image.Point
+	for _, c := range gocv.FindContours(inv, gocv.RetrievalExternal, gocv.ChainApproxNone) {
+		r := gocv.MinAreaRect(c)
+		long, short := max(r.Width, r.Height), min(r.Width, r.Height)
+		if short > 0 && float64(long)/float64(short) > maxDarkElongation {
+			drop = append(drop, c)
 		}
 	}
+	if len(drop) > 0 {
+		gocv.FillPoly(mask, drop, color.RGBA{255, 255, 255, 0})
+	}
+}
 
-	fmt.Println(time.Since(st))
+// darkCentroid returns the centroid of the largest dark region in
+// mask, a black-and-white image. ok is false if mask has no dark
+// regions.
+func darkCentroid(mask gocv.Mat) (centroid image.Point, ok bool) {
+	// FindContours traces white regions, so flip things around
+	// first.
+	inv := gocv.NewMat()
+	defer inv.Close()
+	gocv.BitwiseNot(mask, &inv)
 
-	return approximate, winner
+	var (
+		best     []image.Point
+		bestArea float64
+	)
+	for _, c := range gocv.FindContours(inv, gocv.RetrievalExternal, gocv.ChainApproxNone) {
+		if a := gocv.ContourArea(c); a > bestArea {
+			best, bestArea = c, a
+		}
+	}
+	if best == nil {
+		return image.Point{}, false
+	}
+	return polygonCentroid(best), true
 }
 
-// edgeMap1 computes an edge map using thresholding and hole filling.
-func edgeMap1(src gocv.Mat) gocv.Mat {
-	// Make the darkest 10% of pixels perfectly black, and the rest
-	// perfectly white.
+// polygonCentroid returns the centroid of the area enclosed by the
+// polygon pts.
+func polygonCentroid(pts []image.Point) image.Point {
+	var a, cx, cy float64
+	for i := range pts {
+		p, q := pts[i], pts[(i+1)%len(pts)]
+		cross := float64(p.X*q.Y - q.X*p.Y)
+		a += cross
+		cx += float64(p.X+q.X) * cross
+		cy += float64(p.Y+q.Y) * cross
+	}
+	if a == 0 {
+		// Degenerate polygon (a line or a single point), just
+		// average the points.
+		var sum image.Point
+		for _, p := range pts {
+			sum = sum.Add(p)
+		}
+		return sum.Div(len(pts))
+	}
+	return image.Point{
+		X: int(cx / (3 * a)),
+		Y: int(cy / (3 * a)),
+	}
+}
+
+// darkLevel is the pixel value, out of 255 after contrast
+// stretching, at or below which edgeMap1 takes pixels to be dark by
+// default.
+const darkLevel = 25
+
+// defaultOpenKernel is the size of the kernel that edgeMap1 opens
+// dark regions with by default.
+const defaultOpenKernel = 7
+
+// edgeMap1 computes an edge map using thresholding and hole
+// filling. It also returns the thresholded mask the edges were
+// computed from, in which dark areas are black.
+func edgeMap1(src gocv.Mat, opts *Options) (gocv.Mat, gocv.Mat) {
+	// Make the dark pixels, by default roughly the darkest 10%,
+	// perfectly black, and the rest perfectly white.
 	thresh := gocv.NewMat()
-	gocv.Threshold(src, &thresh, 25, 255, gocv.ThresholdBinary)
+	gocv.Threshold(src, &thresh, opts.darkThreshold(src), 255, gocv.ThresholdBinary)
 
 	// Color in white blotches, so that we have fewer false
 	// edges. This is particularly important for pupil decection,
 	// because it's very common to have the camera's light array
 	// reflected in the center of the pupil, which creates a false
-	// circle. Hole filling completely fixes that.
-	filled := fillHoles(thresh)
+	// circle. Hole filling completely fixes that, as long as the
+	// reflection is fully inside the pupil.
+	filled := fillReflections(thresh)
 
 	// "Open" the image. Opening is a morphological operation where
 	// you "thin" objects, and then "fatten" them back up. For most of
@@ -263,13 +734,37 @@ func edgeMap1(src gocv.Mat) gocv.Mat {
 	// noise, those dots get wiped out during thinning. So effectively
 	// it's a noise-reduction step.
 	opened := gocv.NewMat()
-	gocv.MorphologyEx(filled, &opened, gocv.MorphOpen, gocv.GetStructuringElement(gocv.MorphEllipse, image.Point{7, 7}))
+	k := opts.openKernel()
+	gocv.MorphologyEx(filled, &opened, gocv.MorphOpen, gocv.GetStructuringElement(gocv.MorphEllipse, image.Point{k, k}))
+
+	// Eyebrows and hair are often as dark as the pupil, and their
+	// edges then win votes. Unlike the pupil, they're long and thin,
+	// so drop dark regions of that shape.
+	dropElongated(&opened)
 
 	// Finally, detect edges and get (hopefully) a crisp circle where
 	// the pupil boundary lies.
 	edge := sobelEdge(opened)
 
-	return edge
+	return edge, opened
+}
+
+// percentile returns the smallest pixel value that at least a
+// fraction frac of pix are at or below.
+func percentile(pix []byte, frac float64) int {
+	var hist [256]int
+	for _, p := range pix {
+		hist[p]++
+	}
+	want := int(math.Ceil(frac * float64(len(pix))))
+	n := 0
+	for v, c := range hist {
+		n += c
+		if n >= want {
+			return v
+		}
+	}
+	return 255
 }
 
 // edgeMap2 computes a naive edge map for the image.
@@ -309,6 +804,109 @@ func sobelEdge(src gocv.Mat) gocv.Mat {
 	return ret
 }
 
+// reflectionGap is the widest gap in the pupil's dark ring, in
+// pixels, that fillReflections bridges.
+const reflectionGap = 5
+
+// fillReflections is fillHoles, plus a fix for large reflections.
+//
+// A reflection that nearly fills the pupil leaves only a thin dark
+// ring around it, which thresholding can break. The reflection then
+// connects to the bright iris through the break, isn't a hole any
+// more, and the pupil's blob becomes a crescent. Closing the dark
+// regions bridges such breaks. That also distorts dark regions
+// elsewhere a little, so it's only used if it encloses a new blob at
+// least the size of the bridge.
+func fillReflections(src gocv.Mat) gocv.Mat {
+	filled := fillHoles(src)
+
+	// src is white with black dark regions, so closing the dark is
+	// opening the white.
+	closed := gocv.NewMat()
+	defer closed.Close()
+	kernel := gocv.GetStructuringElement(gocv.MorphEllipse, image.Point{reflectionGap, reflectionGap})
+	defer kernel.Close()
+	gocv.MorphologyEx(src, &closed, gocv.MorphOpen, kernel)
+	closedFilled := fillHoles(closed)
+
+	// Newly enclosed blobs are white after closing, and were white
+	// after plain filling, but got filled in now.
+	enclosed := gocv.NewMat()
+	defer enclosed.Close()
+	gocv.BitwiseNot(closedFilled, &enclosed)
+	gocv.BitwiseAnd(enclosed, filled, &enclosed)
+	gocv.BitwiseAnd(enclosed, closed, &enclosed)
+	if gocv.CountNonZero(enclosed) < reflectionGap*reflectionGap {
+		closedFilled.Close()
+		return filled
+	}
+	filled.Close()
+	return closedFilled
+}
+
+// thinEdges returns the pixels of edge that are on a ridge of im's
+// gradient magnitude, after blurring im with a blurSize Gaussian
+// kernel: the gradient is stronger there than at both neighbors
+// along the gradient direction. This is the non-maximum suppression
+// step of the Canny edge detector, and leaves ridges one pixel wide.
+func thinEdges(edge, im gocv.Mat, blurSize int) gocv.Mat {
+	blur := gocv.NewMat()
+	defer blur.Close()
+	gocv.GaussianBlur(im, &blur, image.Point{blurSize, blurSize}, 0, 0, gocv.BorderDefault)
+	dx, dy := gocv.NewMat(), gocv.NewMat()
+	defer dx.Close()
+	defer dy.Close()
+	gocv.Sobel(blur, &dx, gocv.MatTypeCV32F, 1, 0, 3, 1, 0, gocv.BorderDefault)
+	gocv.Sobel(blur, &dy, gocv.MatTypeCV32F, 0, 1, 3, 1, 0, gocv.BorderDefault)
+	mag := func(p image.Point) float64 {
+		return math.Hypot(float64(floatAt(dx, p)), float64(floatAt(dy, p)))
+	}
+
+	b := pixel.Bounds(edge)
+	ret := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), b.Dy(), b.Dx(), gocv.MatTypeCV8U)
+	inner := b.Inset(1)
+	for y := inner.Min.Y; y < inner.Max.Y; y++ {
+		for x := inner.Min.X; x < inner.Max.X; x++ {
+			p := image.Point{x, y}
+			v := pixel.UCharAt(edge, p)
+			if v == 0 {
+				continue
+			}
+			// Step to the neighbor closest to the gradient
+			// direction, quantized to 45 degrees.
+			n := gradientStep(floatAt(dx, p), floatAt(dy, p))
+			m := mag(p)
+			// Strict on one side, so that a ridge two pixels
+			// wide keeps exactly one of them.
+			if m > mag(p.Add(n)) && m >= mag(p.Sub(n)) {
+				setUCharAt(&ret, p, v)
+			}
+		}
+	}
+	return ret
+}
+
+// gradientStep returns the offset to the pixel neighbor closest to
+// the direction of the gradient (gx, gy).
+func gradientStep(gx, gy float32) image.Point {
+	deg := math.Atan2(float64(gy), float64(gx)) * 180 / math.Pi
+	if deg < 0 {
+		deg += 180
+	}
+	// Opposite directions are the same for suppression, so only
+	// [0, 180) matters.
+	switch {
+	case deg < 22.5 || deg >= 157.5:
+		return image.Point{1, 0}
+	case deg < 67.5:
+		return image.Point{1, 1}
+	case deg < 112.5:
+		return image.Point{0, 1}
+	default:
+		return image.Point{-1, 1}
+	}
+}
+
 // fillHoles fills in white blobs that aren't connected to an
 // edge. The input is assumed to be a binary black-and-white image.
 func fillHoles(src gocv.Mat) gocv.Mat {
@@ -316,13 +914,14 @@ func fillHoles(src gocv.Mat) gocv.Mat {
 
 	// Create a white border around the edge, so that a flood on (0,0)
 	// reaches all white areas reachable from any edge pixel.
-	for row := 0; row < ret.Size()[0]; row++ {
-		ret.SetUCharAt(row, 0, 255)
-		ret.SetUCharAt(row, ret.Size()[1]-1, 255)
+	b := pixel.Bounds(ret)
+	for y := 0; y < b.Dy(); y++ {
+		setUCharAt(&ret, image.Point{0, y}, 255)
+		setUCharAt(&ret, image.Point{b.Dx() - 1, y}, 255)
 	}
-	for col := 1; col < ret.Size()[1]-1; col++ {
-		ret.SetUCharAt(0, col, 255)
-		ret.SetUCharAt(ret.Size()[0]-1, col, 255)
+	for x := 1; x < b.Dx()-1; x++ {
+		setUCharAt(&ret, image.Point{x, 0}, 255)
+		setUCharAt(&ret, image.Point{x, b.Dy() - 1}, 255)
 	}
 
 	// Flood white-to-black from (0,0). This will make everything
@@ -341,11 +940,14 @@ func fillHoles(src gocv.Mat) gocv.Mat {
 // shrink resizes im down so that its height is at most
 // maxHeight. Returns the shrunken image, as well as the factor you'd
 // need to multiply by to get back to the original image.
+//
+// The factor is exact for rows. Columns get rounded separately by
+// the resize, so use axisScales to map coordinates back precisely.
 func shrink(im gocv.Mat, maxHeight int) (gocv.Mat, float64) {
 	ret := im.Clone()
 	mult := float64(1)
 
-	sz := float64(im.Size()[0])
+	sz := float64(pixel.Bounds(im).Dy())
 	tgtSz := float64(maxHeight)
 	if sz > tgtSz {
 		gocv.Resize(ret, &ret, image.Point{}, tgtSz/sz, tgtSz/sz, gocv.InterpolationDefault)
@@ -353,3 +955,17 @@ func shrink(im gocv.Mat, maxHeight int) (gocv.Mat, float64) {
 	}
 	return ret, mult
 }
+
+// axisScales returns the factors by which to multiply horizontal and
+// vertical coordinates in small, a resized copy of big, to get the
+// corresponding coordinates in big.
+//
+// Resizing rounds the new width and height separately, so unless the
+// image is square the two factors differ slightly. The difference is
+// under a thumbnail pixel, but that's several pixels once scaled
+// back up to a large image.
+func axisScales(big, small gocv.Mat) (sx, sy float64) {
+	sx = float64(pixel.Bounds(big).Dx()) / float64(pixel.Bounds(small).Dx())
+	sy = float64(pixel.Bounds(big).Dy()) / float64(pixel.Bounds(small).Dy())
+	return sx, sy
+}
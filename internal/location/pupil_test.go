@@ -0,0 +1,82 @@
+package location
+
+import (
+	"image"
+	"testing"
+)
+
+func TestPolygonCentroid(t *testing.T) {
+	tests := []struct {
+		name string
+		pts  []image.Point
+		want image.Point
+	}{
+		{"square", []image.Point{{0, 0}, {10, 0}, {10, 10}, {0, 10}}, image.Point{5, 5}},
+		{"clockwise square", []image.Point{{0, 0}, {0, 10}, {10, 10}, {10, 0}}, image.Point{5, 5}},
+		{"offset rectangle", []image.Point{{100, 50}, {140, 50}, {140, 70}, {100, 70}}, image.Point{120, 60}},
+		{"triangle", []image.Point{{0, 0}, {30, 0}, {0, 30}}, image.Point{10, 10}},
+		// The centroid of the area, not of the vertices, which are
+		// crowded on the left.
+		{"uneven vertices", []image.Point{{0, 0}, {0, 5}, {0, 10}, {0, 15}, {0, 20}, {20, 20}, {20, 0}}, image.Point{10, 10}},
+		{"line", []image.Point{{0, 0}, {10, 10}}, image.Point{5, 5}},
+		{"point", []image.Point{{7, 3}}, image.Point{7, 3}},
+	}
+	for _, test := range tests {
+		if got := polygonCentroid(test.pts); got != test.want {
+			t.Errorf("%s: polygonCentroid = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNormalizedNonSquare(t *testing.T) {
+	c := Circle{Point: image.Point{470, 95}, R: 30}
+	tests := []struct {
+		b    image.Rectangle
+		want NormalizedCircle
+	}{
+		{image.Rect(0, 0, 640, 200), NormalizedCircle{X: 470.0 / 640, Y: 95.0 / 200, R: 30.0 / 200}},
+		{image.Rect(0, 0, 500, 1000), NormalizedCircle{X: 470.0 / 500, Y: 95.0 / 1000, R: 30.0 / 1000}},
+		{image.Rect(70, 45, 570, 245), NormalizedCircle{X: 400.0 / 500, Y: 50.0 / 200, R: 30.0 / 200}},
+	}
+	for _, test := range tests {
+		if got := c.Normalized(test.b); got != test.want {
+			t.Errorf("%s.Normalized(%v) = %s, want %s", c, test.b, got, test.want)
+		}
+	}
+}
+
+func TestCircleScale(t *testing.T) {
+	c := Circle{Point: image.Point{100, 40}, R: 20}
+	if got, want := c.scale(3, 1.5), (Circle{Point: image.Point{300, 60}, R: 45}); got != want {
+		t.Errorf("%s.scale(3, 1.5) = %s, want %s", c, got, want)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	// 100 pixels, valued 0 to 99.
+	pix := make([]byte, 100)
+	for i := range pix {
+		pix[i] = byte(i)
+	}
+	tests := []struct {
+		frac float64
+		want int
+	}{
+		{0.01, 0},
+		{0.1, 9},
+		{0.105, 10},
+		{0.5, 49},
+		{1, 99},
+	}
+	for _, test := range tests {
+		if got := percentile(pix, test.frac); got != test.want {
+			t.Errorf("percentile(0..99, %g) = %d, want %d", test.frac, got, test.want)
+		}
+	}
+
+	// Ties all go to the same level.
+	flat := []byte{3, 3, 3, 3, 200}
+	if got := percentile(flat, 0.1); got != 3 {
+		t.Errorf("percentile(%v, 0.1) = %d, want 3", flat, got)
+	}
+}
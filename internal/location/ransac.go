@@ -0,0 +1,165 @@
+package location
+
+import (
+	"context"
+	"image"
+	"math"
+	"math/rand"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+const (
+	// ransacIterations is the number of random point triplets
+	// tried by fitRANSAC.
+	ransacIterations = 1000
+	// ransacTolerance is how far, in pixels, an edge point may be
+	// from a candidate circle and still count as supporting it.
+	ransacTolerance = 1.5
+)
+
+// fitRANSAC finds the single best defined circle in im, using RANSAC
+// instead of a Hough transform. Like findBestCircle, it returns an
+// approximate circle and a refined one.
+//
+// Input pixels should be zero for non-candidate points, any other
+// value is assumed to be a point on the circle we're looking for.
+func fitRANSAC(ctx context.Context, im gocv.Mat, opts *Options) (Circle, Circle) {
+	// Hough voting does work proportional to the number of edge
+	// pixels times the number of radii, and quantizes everything to
+	// the accumulator grid. On sparse edge maps, it's both faster
+	// and more precise to just guess circles from the edge points
+	// themselves: any 3 points on the pupil edge define the pupil
+	// circle exactly.
+	var pts []image.Point
+	b := pixel.Bounds(im)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if p := (image.Point{x, y}); pixel.UCharAt(im, p) != 0 {
+				pts = append(pts, p)
+			}
+		}
+	}
+	if len(pts) < 3 {
+		return Circle{}, Circle{}
+	}
+
+	// Only consider radii that the Hough search would consider.
+	minR, maxR := opts.pupilRadii(b.Dy())
+
+	// Use a fixed seed, so that results are reproducible from one
+	// run to the next.
+	rnd := rand.New(rand.NewSource(1))
+
+	var (
+		winnerVotes int
+		winnerFit   [3]float64
+	)
+	for i := 0; i < ransacIterations && ctx.Err() == nil; i++ {
+		a, b, c := pts[rnd.Intn(len(pts))], pts[rnd.Intn(len(pts))], pts[rnd.Intn(len(pts))]
+		x, y, r, ok := circleThrough(a, b, c)
+		if !ok || r < minR || r >= maxR {
+			continue
+		}
+
+		votes := 0
+		for _, p := range pts {
+			if math.Abs(math.Hypot(float64(p.X)-x, float64(p.Y)-y)-r) <= ransacTolerance {
+				votes++
+			}
+		}
+		if votes > winnerVotes {
+			winnerVotes = votes
+			winnerFit = [3]float64{x, y, r}
+		}
+	}
+	if winnerVotes == 0 {
+		return Circle{}, Circle{}
+	}
+	winner := roundCircle(winnerFit[0], winnerFit[1], winnerFit[2])
+
+	// The winning triplet is just 3 points, so its circle is only
+	// as good as those points. Refit to all the inliers with least
+	// squares, which averages out the pixel quantization.
+	var inliers []image.Point
+	for _, p := range pts {
+		if math.Abs(math.Hypot(float64(p.X)-winnerFit[0], float64(p.Y)-winnerFit[1])-winnerFit[2]) <= ransacTolerance {
+			inliers = append(inliers, p)
+		}
+	}
+	x, y, r, ok := leastSquaresCircle(inliers)
+	if !ok {
+		return winner, winner
+	}
+	return winner, roundCircle(x, y, r)
+}
+
+// circleThrough returns the center and radius of the circle passing
+// through a, b and c. ok is false if the points are collinear.
+func circleThrough(a, b, c image.Point) (x, y, r float64, ok bool) {
+	ax, ay := float64(a.X), float64(a.Y)
+	bx, by := float64(b.X), float64(b.Y)
+	cx, cy := float64(c.X), float64(c.Y)
+
+	d := 2 * (ax*(by-cy) + bx*(cy-ay) + cx*(ay-by))
+	if math.Abs(d) < 1e-9 {
+		return 0, 0, 0, false
+	}
+	a2, b2, c2 := ax*ax+ay*ay, bx*bx+by*by, cx*cx+cy*cy
+	x = (a2*(by-cy) + b2*(cy-ay) + c2*(ay-by)) / d
+	y = (a2*(cx-bx) + b2*(ax-cx) + c2*(bx-ax)) / d
+	return x, y, math.Hypot(ax-x, ay-y), true
+}
+
+// leastSquaresCircle fits a circle to pts, minimizing algebraic
+// distance (the "Kåsa fit"). ok is false if there aren't enough
+// points, or they're degenerate.
+func leastSquaresCircle(pts []image.Point) (x, y, r float64, ok bool) {
+	if len(pts) < 3 {
+		return 0, 0, 0, false
+	}
+
+	// A circle is x² + y² + Dx + Ey + F = 0. That's linear in D, E
+	// and F, so we can solve the normal equations directly.
+	var sx, sy, sxx, syy, sxy, sxz, syz, sz float64
+	for _, p := range pts {
+		px, py := float64(p.X), float64(p.Y)
+		z := px*px + py*py
+		sx += px
+		sy += py
+		sxx += px * px
+		syy += py * py
+		sxy += px * py
+		sxz += px * z
+		syz += py * z
+		sz += z
+	}
+	n := float64(len(pts))
+	m := [][]float64{
+		{sxx, sxy, sx},
+		{sxy, syy, sy},
+		{sx, sy, n},
+	}
+	v := []float64{-sxz, -syz, -sz}
+	sol, ok := solve(m, v)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	x, y = -sol[0]/2, -sol[1]/2
+	r2 := x*x + y*y - sol[2]
+	if r2 <= 0 {
+		return 0, 0, 0, false
+	}
+	return x, y, math.Sqrt(r2), true
+}
+
+// roundCircle returns the Circle closest to the given center and
+// radius.
+func roundCircle(x, y, r float64) Circle {
+	return Circle{
+		Point: image.Point{int(math.Round(x)), int(math.Round(y))},
+		R:     int(math.Round(r)),
+	}
+}
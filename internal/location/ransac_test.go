@@ -0,0 +1,69 @@
+package location
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestCircleThrough(t *testing.T) {
+	x, y, r, ok := circleThrough(image.Point{10, 0}, image.Point{0, 10}, image.Point{-10, 0})
+	if !ok || math.Abs(x) > 1e-9 || math.Abs(y) > 1e-9 || math.Abs(r-10) > 1e-9 {
+		t.Errorf("circleThrough = (%v,%v,%v), %v, want (0,0,10), true", x, y, r, ok)
+	}
+
+	x, y, r, ok = circleThrough(image.Point{130, 40}, image.Point{100, 70}, image.Point{70, 40})
+	if !ok || math.Abs(x-100) > 1e-9 || math.Abs(y-40) > 1e-9 || math.Abs(r-30) > 1e-9 {
+		t.Errorf("circleThrough = (%v,%v,%v), %v, want (100,40,30), true", x, y, r, ok)
+	}
+
+	if _, _, _, ok := circleThrough(image.Point{0, 0}, image.Point{5, 5}, image.Point{10, 10}); ok {
+		t.Error("circleThrough of collinear points succeeded")
+	}
+	if _, _, _, ok := circleThrough(image.Point{3, 4}, image.Point{3, 4}, image.Point{10, 10}); ok {
+		t.Error("circleThrough of repeated points succeeded")
+	}
+}
+
+// ringPoints returns n points evenly spread around the circle
+// (x, y, r), rounded to pixels.
+func ringPoints(x, y, r float64, n int) []image.Point {
+	var ret []image.Point
+	for i := 0; i < n; i++ {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		ret = append(ret, image.Point{int(math.Round(x + r*math.Cos(a))), int(math.Round(y + r*math.Sin(a)))})
+	}
+	return ret
+}
+
+func TestLeastSquaresCircle(t *testing.T) {
+	tests := []struct {
+		x, y, r float64
+	}{
+		{0, 0, 10},
+		{320, 240, 50},
+		// Far from the origin, where the normal equations are
+		// worst conditioned.
+		{1800, 950, 12},
+	}
+	for _, test := range tests {
+		x, y, r, ok := leastSquaresCircle(ringPoints(test.x, test.y, test.r, 72))
+		if !ok || math.Abs(x-test.x) > 0.5 || math.Abs(y-test.y) > 0.5 || math.Abs(r-test.r) > 0.5 {
+			t.Errorf("leastSquaresCircle of (%v,%v,%v) = (%.2f,%.2f,%.2f), %v", test.x, test.y, test.r, x, y, r, ok)
+		}
+	}
+
+	// A partial arc still pins down the circle.
+	arc := ringPoints(200, 100, 40, 72)[:24]
+	if x, y, r, ok := leastSquaresCircle(arc); !ok || math.Abs(x-200) > 1 || math.Abs(y-100) > 1 || math.Abs(r-40) > 1 {
+		t.Errorf("leastSquaresCircle of a third of (200,100,40) = (%.2f,%.2f,%.2f), %v", x, y, r, ok)
+	}
+
+	if _, _, _, ok := leastSquaresCircle(ringPoints(0, 0, 10, 2)); ok {
+		t.Error("leastSquaresCircle of 2 points succeeded")
+	}
+	line := []image.Point{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}
+	if _, _, _, ok := leastSquaresCircle(line); ok {
+		t.Error("leastSquaresCircle of a line succeeded")
+	}
+}
@@ -0,0 +1,153 @@
+package location
+
+import (
+	"fmt"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/houghcircle"
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+// A Reason is a likely cause of a failed or unconfident localization.
+// Each points at a different fix: lighting, focus, capture distance,
+// or the fitter's parameters.
+type Reason int
+
+const (
+	// ReasonFewEdges means the edge map held too few edge pixels to
+	// outline even the smallest expected pupil. The image is likely
+	// too dark or too flat, or the pupil is out of frame.
+	ReasonFewEdges Reason = iota
+	// ReasonNoisyEdges means the edge map stayed too dense even
+	// after the strongest blur, so the fitter voted mostly on noise.
+	// Eyelashes, glasses or heavy sensor noise are typical causes.
+	ReasonNoisyEdges
+	// ReasonWeakPeak means the best circle in the Hough accumulator
+	// was supported by only a small part of its circumference. The
+	// pupil may be mostly occluded, or outside the configured radius
+	// range.
+	ReasonWeakPeak
+	// ReasonNotDark means the inside of the found circle isn't
+	// clearly darker than the ring around it, which a pupil always
+	// is. The lighting may be wrong, e.g. visible light on a dark
+	// iris, or the fitter latched onto something else.
+	ReasonNotDark
+	// ReasonBlurry means the image has little high frequency detail
+	// overall, from bad focus or motion blur.
+	ReasonBlurry
+)
+
+// Quality reports whether r is a problem with the image itself, from
+// its focus, noise or lighting, rather than with finding the pupil in
+// it. Recapturing fixes the former, retuning the fitter the latter.
+func (r Reason) Quality() bool {
+	switch r {
+	case ReasonFewEdges, ReasonNoisyEdges, ReasonBlurry:
+		return true
+	default:
+		return false
+	}
+}
+
+// reasonNames maps Reasons to their machine-readable names.
+var reasonNames = map[Reason]string{
+	ReasonFewEdges:   "few-edges",
+	ReasonNoisyEdges: "noisy-edges",
+	ReasonWeakPeak:   "weak-peak",
+	ReasonNotDark:    "not-dark",
+	ReasonBlurry:     "blurry",
+}
+
+func (r Reason) String() string {
+	if s, ok := reasonNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Reason(%d)", int(r))
+}
+
+const (
+	// minEdgeSupport is the fraction of the smallest expected pupil's
+	// circumference that the edge map must hold in edge pixels.
+	minEdgeSupport = 0.5
+	// minPeakSupport is the fraction of the found pupil's rasterized
+	// circle that must have voted for it. Like minRefineSupport, it
+	// counts the circle's pixels rather than its length, which
+	// rasterization caps at 360 points.
+	minPeakSupport = 0.3
+	// minSharpness is the mean absolute Laplacian, on a normalized
+	// image, below which it counts as blurry. Sharp eye images,
+	// with eyelashes and iris texture, are well above it.
+	minSharpness = 2
+)
+
+// explain returns the likely reasons why r, found in im with opts,
+// is missing or unconfident.
+func explain(im gocv.Mat, r PupilResult, opts *Options) []Reason {
+	var ret []Reason
+	b := pixel.Bounds(im)
+	diag := r.Diagnostics
+	// Only the edge-based fitters fill in EdgeBlur.
+	if diag.EdgeBlur > 0 {
+		minR, _ := opts.pupilRadii(b.Dy())
+		edges := diag.EdgeDensity * float64(b.Dx()*b.Dy())
+		if edges < minEdgeSupport*2*math.Pi*minR {
+			ret = append(ret, ReasonFewEdges)
+		}
+		if diag.EdgeDensity > maxEdgeDensity {
+			ret = append(ret, ReasonNoisyEdges)
+		}
+	}
+	if r.Fitter == FitHough && (r.Pupil.R == 0 || float64(diag.RefinedVotes) < minPeakSupport*float64(len(houghcircle.Points(r.Pupil.R)))) {
+		ret = append(ret, ReasonWeakPeak)
+	}
+
+	norm := gocv.NewMat()
+	defer norm.Close()
+	gocv.Normalize(im, &norm, 255.0, 0.0, gocv.NormMinMax)
+	if r.Pupil.R > 0 && !darkInside(norm, r.Pupil) {
+		ret = append(ret, ReasonNotDark)
+	}
+	if sharpness(norm) < minSharpness {
+		ret = append(ret, ReasonBlurry)
+	}
+	return ret
+}
+
+// darkInside reports whether the disc of c in the normalized image
+// norm is darker on average than the ring half a radius wide around
+// it, by at least confidenceContrast.
+func darkInside(norm gocv.Mat, c Circle) bool {
+	// Skip a couple of pixels either side of the boundary, like
+	// pupilConfidence, so that being off by a pixel doesn't matter.
+	const margin = 2
+	polar := unwrapPolar(norm, c.Point, 0, c.R*3/2)
+	var in, out float64
+	var nIn, nOut int
+	for _, ray := range polar.at {
+		for i, v := range ray {
+			switch r := polar.minR + i; {
+			case r < c.R-margin:
+				in += v
+				nIn++
+			case r > c.R+margin:
+				out += v
+				nOut++
+			}
+		}
+	}
+	if nIn == 0 || nOut == 0 {
+		return false
+	}
+	return out/float64(nOut)-in/float64(nIn) >= confidenceContrast
+}
+
+// sharpness returns the mean absolute Laplacian of im.
+func sharpness(im gocv.Mat) float64 {
+	lap := gocv.NewMat()
+	defer lap.Close()
+	gocv.Laplacian(im, &lap, gocv.MatTypeCV16S, 3, 1, 0, gocv.BorderDefault)
+	gocv.ConvertScaleAbs(lap, &lap, 1, 0)
+	return lap.Mean().Val1
+}
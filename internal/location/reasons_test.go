@@ -0,0 +1,21 @@
+package location
+
+import "testing"
+
+func TestReasonQuality(t *testing.T) {
+	tests := map[Reason]bool{
+		ReasonFewEdges:   true,
+		ReasonNoisyEdges: true,
+		ReasonWeakPeak:   false,
+		ReasonNotDark:    false,
+		ReasonBlurry:     true,
+	}
+	for r, want := range tests {
+		if got := r.Quality(); got != want {
+			t.Errorf("%s.Quality() = %v, want %v", r, got, want)
+		}
+	}
+	if len(tests) != len(reasonNames) {
+		t.Errorf("tested %d reasons, but there are %d", len(tests), len(reasonNames))
+	}
+}
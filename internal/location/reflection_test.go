@@ -0,0 +1,70 @@
+//go:build opencv
+// +build opencv
+
+package location
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+var (
+	white = color.RGBA{255, 255, 255, 255}
+	black = color.RGBA{0, 0, 0, 255}
+)
+
+func TestFillReflections(t *testing.T) {
+	// A dark pupil whose reflection leaves only a thin rim on the
+	// right, and a gap in the rim narrower than reflectionGap, as
+	// thresholding leaves it.
+	mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(255, 0, 0, 0), 200, 200, gocv.MatTypeCV8U)
+	defer mask.Close()
+	gocv.Circle(&mask, image.Point{100, 100}, 40, black, -1)
+	gocv.Circle(&mask, image.Point{102, 100}, 37, white, -1)
+	gocv.Line(&mask, image.Point{135, 100}, image.Point{145, 100}, white, 3)
+
+	inside := image.Point{102, 100}
+	plain := fillHoles(mask)
+	defer plain.Close()
+	if pixel.UCharAt(plain, inside) == 0 {
+		t.Fatal("test setup: the reflection is enclosed without closing")
+	}
+
+	filled := fillReflections(mask)
+	defer filled.Close()
+	if v := pixel.UCharAt(filled, inside); v != 0 {
+		t.Errorf("reflection not filled, pixel at %v is %d", inside, v)
+	}
+	// Outside the pupil, nothing changes.
+	for _, p := range []image.Point{{10, 10}, {190, 100}, {100, 190}} {
+		if v := pixel.UCharAt(filled, p); v != 255 {
+			t.Errorf("background at %v became %d", p, v)
+		}
+	}
+}
+
+func TestLocatePupilBehindBrokenReflection(t *testing.T) {
+	im := SyntheticEye(image.Rect(0, 0, 640, 480), selfTestPupil)
+	defer im.Close()
+	gocv.Circle(&im, selfTestReflection.Point, selfTestReflection.R, white, -1)
+	// Break the thin rim the reflection leaves, so that the
+	// reflection touches the iris.
+	right := selfTestPupil.X + selfTestPupil.R
+	gocv.Line(&im, image.Point{right - 4, selfTestPupil.Y}, image.Point{right + 2, selfTestPupil.Y}, color.RGBA{110, 110, 110, 255}, 3)
+
+	for _, f := range []Fitter{FitHough, FitRANSAC} {
+		r, err := LocatePupilContext(context.Background(), im, &Options{Fitter: f})
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if err := nearCircle(r.Pupil, selfTestPupil, selfTestTolerance); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+}
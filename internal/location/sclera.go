@@ -8,6 +8,7 @@ import (
 	"gocv.io/x/gocv"
 
 	"go.universe.tf/iris/internal/debug"
+	"go.universe.tf/iris/internal/geometry/pixel"
 )
 
 func min(a, b int) int {
@@ -43,12 +44,17 @@ func FindSclera(im gocv.Mat, pupil Circle) {
 			Y: max(pupil.Y-int(halfHeight), 0),
 		},
 		Max: image.Point{
-			X: min(pupil.X+int(halfWidth), im.Size()[1]),
-			Y: min(pupil.Y+int(halfHeight), im.Size()[0]),
+			X: min(pupil.X+int(halfWidth), pixel.Bounds(im).Dx()),
+			Y: min(pupil.Y+int(halfHeight), pixel.Bounds(im).Dy()),
 		},
 	}
 
+	// From here on, we're working in the cropped region's
+	// coordinates, so the pupil needs translating too. Anything we
+	// find in the region must have bounding.Min added back before
+	// it's reported to the caller.
 	im = im.Region(bounding)
+	pupil.Point = pupil.Point.Sub(bounding.Min)
 
 	norm := gocv.NewMat()
 	gocv.Normalize(im, &norm, 255.0, 0.0, gocv.NormMinMax)
@@ -71,8 +77,8 @@ func FindSclera(im gocv.Mat, pupil Circle) {
 			Y: 0,
 		},
 		Max: image.Point{
-			X: min(pupil.X+widerPupil, dx.Size()[1]),
-			Y: dx.Size()[0],
+			X: min(pupil.X+widerPupil, pixel.Bounds(dx).Dx()),
+			Y: pixel.Bounds(dx).Dy(),
 		},
 	}
 
@@ -0,0 +1,216 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+// The segmentation network's contract. The model takes a 1x1xHxW
+// float input, the grayscale image scaled to [0,1] and resized to
+// segmentWidth x segmentHeight. It outputs 1xCxHxW per-class scores,
+// with C >= 4 and the first classes in the order below.
+const (
+	segmentWidth  = 320
+	segmentHeight = 240
+
+	classBackground = 0
+	classSclera     = 1
+	classIris       = 2
+	classPupil      = 3
+	numClasses      = 4
+)
+
+// Segmentation is a per-pixel classification of an eye image. Each
+// mask is the same size as the input image, with the class's pixels
+// set to 255 and everything else to 0.
+type Segmentation struct {
+	Pupil  gocv.Mat
+	Iris   gocv.Mat
+	Sclera gocv.Mat
+}
+
+// Close releases the masks.
+func (s *Segmentation) Close() {
+	s.Pupil.Close()
+	s.Iris.Close()
+	s.Sclera.Close()
+}
+
+// netKey identifies a loaded network.
+type netKey struct {
+	path  string
+	accel Accelerator
+}
+
+var (
+	netsMu sync.Mutex
+	// nets caches loaded networks by model path and accelerator,
+	// since loading one takes far longer than running it.
+	nets = map[netKey]*gocv.Net{}
+)
+
+// Segment classifies every pixel of im as pupil, iris, sclera or
+// background, using the semantic segmentation model at modelPath. The
+// model can be in any format OpenCV's DNN module can read, ONNX
+// included.
+//
+// Classical edge-based localization struggles with visible light
+// images and off-angle gazes, where pupil and iris contrast is poor
+// and boundaries aren't circular. A trained network handles those far
+// better, at a much higher CPU cost.
+func Segment(im gocv.Mat, modelPath string) (*Segmentation, error) {
+	return segment(im, modelPath, AccelCPU)
+}
+
+// segment is Segment, running the network on accel.
+func segment(im gocv.Mat, modelPath string, accel Accelerator) (*Segmentation, error) {
+	if modelPath == "" {
+		return nil, errors.New("no segmentation model configured")
+	}
+
+	netsMu.Lock()
+	defer netsMu.Unlock()
+
+	key := netKey{modelPath, accel}
+	net, ok := nets[key]
+	if !ok {
+		n := gocv.ReadNet(modelPath, "")
+		if n.Empty() {
+			return nil, errs.New(errs.ErrUnsupportedFormat, "loading segmentation model %q failed", modelPath)
+		}
+		if accel == AccelOpenCL {
+			// gocv calls the OpenCL target FP32, as opposed to
+			// FP16, the half precision OpenCL target.
+			if err := n.SetPreferableTarget(gocv.NetTargetFP32); err != nil {
+				n.Close()
+				return nil, fmt.Errorf("selecting OpenCL for segmentation: %v", err)
+			}
+		}
+		net = &n
+		nets[key] = net
+	}
+
+	blob := gocv.BlobFromImage(im, 1.0/255, image.Point{segmentWidth, segmentHeight}, gocv.NewScalar(0, 0, 0, 0), false, false)
+	defer blob.Close()
+	net.SetInput(blob, "")
+	out := net.Forward("")
+	defer out.Close()
+
+	if c := int(gocv.GetBlobSize(out).Val2); c < numClasses {
+		return nil, errs.New(errs.ErrUnsupportedFormat, "segmentation model outputs %d classes, need at least %d", c, numClasses)
+	}
+
+	var scores [numClasses]gocv.Mat
+	for c := range scores {
+		scores[c] = gocv.GetBlobChannel(out, 0, c)
+		defer scores[c].Close()
+	}
+
+	// Each pixel belongs to whichever class scored highest.
+	var masks [numClasses]gocv.Mat
+	for c := range masks {
+		masks[c] = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), segmentHeight, segmentWidth, gocv.MatTypeCV8U)
+		defer masks[c].Close()
+	}
+	b := pixel.Bounds(scores[0])
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			p := image.Point{x, y}
+			best, bestScore := 0, floatAt(scores[0], p)
+			for c := 1; c < numClasses; c++ {
+				if s := floatAt(scores[c], p); s > bestScore {
+					best, bestScore = c, s
+				}
+			}
+			setUCharAt(&masks[best], p, 255)
+		}
+	}
+
+	// Scale the masks back up to the input's size. Nearest neighbor
+	// keeps them binary.
+	sz := image.Point{pixel.Bounds(im).Dx(), pixel.Bounds(im).Dy()}
+	ret := &Segmentation{
+		Pupil:  gocv.NewMat(),
+		Iris:   gocv.NewMat(),
+		Sclera: gocv.NewMat(),
+	}
+	gocv.Resize(masks[classPupil], &ret.Pupil, sz, 0, 0, gocv.InterpolationNearestNeighbor)
+	gocv.Resize(masks[classIris], &ret.Iris, sz, 0, 0, gocv.InterpolationNearestNeighbor)
+	gocv.Resize(masks[classSclera], &ret.Sclera, sz, 0, 0, gocv.InterpolationNearestNeighbor)
+	return ret, nil
+}
+
+// Limbus returns the outer boundary of the iris, as a circle fitted to
+// the iris and pupil masks together. It returns a zero Circle if
+// there's no iris in the segmentation.
+func (s *Segmentation) Limbus() Circle {
+	eye := gocv.NewMat()
+	defer eye.Close()
+	gocv.BitwiseOr(s.Iris, s.Pupil, &eye)
+	_, ret := circleFromMask(eye)
+	return ret
+}
+
+// Noise returns a mask of the pixels that are neither iris nor pupil:
+// eyelids, eyelashes, reflections and the like. Within the limbus,
+// those are the pixels that occlude the iris texture.
+func (s *Segmentation) Noise() gocv.Mat {
+	ret := gocv.NewMat()
+	gocv.BitwiseOr(s.Iris, s.Pupil, &ret)
+	gocv.BitwiseNot(ret, &ret)
+	return ret
+}
+
+// segmentPupil locates the pupil in im by segmenting it with a
+// neural network, and fitting a circle to the pupil mask. It returns
+// zero Circles if segmentation fails.
+func segmentPupil(im gocv.Mat, opts *Options) (Circle, Circle) {
+	seg, err := segment(im, opts.ModelPath, opts.Accelerator)
+	if err != nil {
+		return Circle{}, Circle{}
+	}
+	defer seg.Close()
+	return circleFromMask(seg.Pupil)
+}
+
+// circleFromMask fits a circle to the largest white blob in mask. The
+// approximate circle is the blob's centroid with the radius of a
+// circle of the same area. The refined one is a least squares fit to
+// the blob's outline.
+func circleFromMask(mask gocv.Mat) (Circle, Circle) {
+	// FindContours scribbles on its input.
+	m := mask.Clone()
+	defer m.Close()
+
+	var (
+		best     []image.Point
+		bestArea float64
+	)
+	for _, c := range gocv.FindContours(m, gocv.RetrievalExternal, gocv.ChainApproxNone) {
+		if a := gocv.ContourArea(c); a > bestArea {
+			best, bestArea = c, a
+		}
+	}
+	if best == nil {
+		return Circle{}, Circle{}
+	}
+
+	center := polygonCentroid(best)
+	approximate := Circle{
+		Point: center,
+		R:     int(math.Round(math.Sqrt(bestArea / math.Pi))),
+	}
+	x, y, r, ok := leastSquaresCircle(best)
+	if !ok {
+		return approximate, approximate
+	}
+	return approximate, roundCircle(x, y, r)
+}
@@ -0,0 +1,93 @@
+package location
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+
+	"gocv.io/x/gocv"
+)
+
+// selfTestPupil is the pupil drawn in SelfTest's synthetic eye. Its
+// radius is within the default pupil radius range for a VGA image.
+var selfTestPupil = Circle{Point: image.Point{330, 250}, R: 50}
+
+// selfTestReflection is a specular reflection that nearly fills
+// selfTestPupil, leaving a thin dark rim on one side. Thresholding
+// tends to break such a rim, which once turned the pupil into a
+// crescent.
+var selfTestReflection = Circle{Point: image.Point{350, 250}, R: 29}
+
+// selfTestTolerance is how far, in pixels, SelfTest lets the located
+// pupil be from the drawn one, on both center and radius.
+const selfTestTolerance = 3
+
+// SelfTest runs a synthetic eye image through the localization
+// pipeline configured by opts, and returns an error if anything is
+// broken. It is meant to run once at startup, so that
+// misconfiguration fails immediately rather than on the first real
+// image.
+//
+// If opts uses a segmentation model, the model gets loaded and run,
+// which catches missing or broken model files. The synthetic eye is
+// a cartoon that a network isn't trained on, so the CNN's answer
+// isn't checked, only that it runs. The classical fitters must find
+// the drawn pupil, both plain and behind a large reflection.
+func SelfTest(ctx context.Context, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	im := SyntheticEye(image.Rect(0, 0, 640, 480), selfTestPupil)
+	defer im.Close()
+
+	if opts.ModelPath != "" {
+		seg, err := Segment(im, opts.ModelPath)
+		if err != nil {
+			return fmt.Errorf("self-test: %w", err)
+		}
+		seg.Close()
+	}
+
+	// Only the classical fitters are held to finding the pupil, so
+	// run without fallbacks, and skip the check entirely if the
+	// primary fitter is the CNN.
+	if opts.Fitter == FitCNN {
+		return nil
+	}
+	classical := *opts
+	classical.Fallback = nil
+	if err := checkPupil(ctx, im, &classical, ""); err != nil {
+		return err
+	}
+
+	gocv.Circle(&im, selfTestReflection.Point, selfTestReflection.R, color.RGBA{255, 255, 255, 255}, -1)
+	return checkPupil(ctx, im, &classical, " behind reflection")
+}
+
+// checkPupil returns an error if the pupil found in im isn't
+// selfTestPupil. what describes the test case in the error.
+func checkPupil(ctx context.Context, im gocv.Mat, opts *Options, what string) error {
+	r, err := LocatePupilContext(ctx, im, opts)
+	if err != nil {
+		return fmt.Errorf("self-test: %w", err)
+	}
+	d := r.Pupil.Point.Sub(selfTestPupil.Point)
+	dr := r.Pupil.R - selfTestPupil.R
+	if d.X*d.X+d.Y*d.Y > selfTestTolerance*selfTestTolerance || dr < -selfTestTolerance || dr > selfTestTolerance {
+		return fmt.Errorf("self-test: %s fitter found pupil%s %s, want %s", r.Fitter, what, r.Pupil, selfTestPupil)
+	}
+	return nil
+}
+
+// SyntheticEye returns a cartoon eye image of size b: a dark pupil
+// inside a mid-gray iris 2.5 times its size, on a bright background.
+// It's good enough to exercise the whole pipeline, but no substitute
+// for real images when it comes to accuracy.
+func SyntheticEye(b image.Rectangle, pupil Circle) gocv.Mat {
+	im := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(200, 0, 0, 0), b.Dy(), b.Dx(), gocv.MatTypeCV8U)
+	gocv.Circle(&im, pupil.Point, pupil.R*5/2, color.RGBA{110, 110, 110, 255}, -1)
+	gocv.Circle(&im, pupil.Point, pupil.R, color.RGBA{30, 30, 30, 255}, -1)
+	return im
+}
@@ -0,0 +1,142 @@
+package location
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+const (
+	// starburstRays is the number of rays cast from the current
+	// center guess.
+	starburstRays = 18
+	// starburstThreshold is the minimum brightness step, on a
+	// normalized image, that counts as crossing the pupil boundary.
+	starburstThreshold = 20
+	// starburstIterations bounds the number of times the center
+	// guess gets moved before we give up on convergence.
+	starburstIterations = 10
+)
+
+// FindPupilEllipse locates the pupil in im as an ellipse, starting
+// from a guess of its center.
+//
+// This is a variant of the Starburst algorithm from "Starburst: A
+// hybrid algorithm for video-based eye tracking combining
+// feature-based and model-based approaches", by Li, Winfield and
+// Parkhurst. It's intended for eye tracking: given the pupil center
+// from the previous frame as a guess, it only looks at a few hundred
+// pixels, so it runs comfortably at hundreds of frames per second. It
+// also models the pupil as an ellipse, which matters when the eye
+// isn't looking straight at the camera.
+//
+// If no good guess is available, use the center of the circle found
+// by FindPupil.
+func FindPupilEllipse(im gocv.Mat, guess image.Point) (Ellipse, error) {
+	norm := gocv.NewMat()
+	defer norm.Close()
+	gocv.Normalize(im, &norm, 255.0, 0.0, gocv.NormMinMax)
+	gocv.GaussianBlur(norm, &norm, image.Point{5, 5}, 0, 0, gocv.BorderDefault)
+
+	// Cast rays outwards from the guess, and stop each one where it
+	// steps from dark to bright. Those feature points should mostly
+	// be on the pupil boundary. Then cast more rays back from each
+	// feature point towards the guess, which picks up boundary points
+	// on the far side of the pupil, in case the guess was
+	// off-center. The centroid of everything we found is a better
+	// guess, so go again until it stops moving.
+	center := guess
+	var pts []image.Point
+	for i := 0; i < starburstIterations; i++ {
+		pts = pts[:0]
+		for ray := 0; ray < starburstRays; ray++ {
+			angle := 2 * math.Pi * float64(ray) / starburstRays
+			p, ok := starburstRay(norm, center, angle)
+			if !ok {
+				continue
+			}
+			pts = append(pts, p)
+
+			back := math.Atan2(float64(center.Y-p.Y), float64(center.X-p.X))
+			for j := -2; j <= 2; j++ {
+				// Fan out ±50° around the direction back towards
+				// the center.
+				q, ok := starburstRay(norm, p, back+float64(j)*(50*math.Pi/180)/2)
+				if ok {
+					pts = append(pts, q)
+				}
+			}
+		}
+		if len(pts) == 0 {
+			return Ellipse{}, errs.New(errs.ErrNotFound, "no pupil boundary points found")
+		}
+
+		var next image.Point
+		for _, p := range pts {
+			next = next.Add(p)
+		}
+		next = next.Div(len(pts))
+		if d := next.Sub(center); d.X*d.X+d.Y*d.Y <= 1 {
+			center = next
+			break
+		}
+		center = next
+	}
+
+	// Some feature points are bound to be on eyelashes, eyelids or
+	// reflections. Fit once, throw away the points that are far from
+	// that fit, and fit again.
+	e, ok := fitEllipse(pts)
+	if !ok {
+		return Ellipse{}, errs.New(errs.ErrNotFound, "pupil boundary points don't form an ellipse")
+	}
+	dists := make([]float64, len(pts))
+	for i, p := range pts {
+		dists[i] = e.distance(p)
+	}
+	sorted := append([]float64(nil), dists...)
+	sort.Float64s(sorted)
+	cutoff := math.Max(2*sorted[len(sorted)/2], 1)
+	var inliers []image.Point
+	for i, p := range pts {
+		if dists[i] <= cutoff {
+			inliers = append(inliers, p)
+		}
+	}
+	if refit, ok := fitEllipse(inliers); ok {
+		e = refit
+	}
+
+	return e, nil
+}
+
+// starburstRay walks from start in the direction of angle (in
+// radians), and returns the first point where brightness increases
+// sharply. ok is false if the ray leaves the image first.
+func starburstRay(im gocv.Mat, start image.Point, angle float64) (image.Point, bool) {
+	dx, dy := math.Cos(angle), math.Sin(angle)
+	// Skip the first few pixels, so that rays cast back from a
+	// boundary point don't immediately re-trigger on it.
+	const skip = 3
+	prev := -1
+	b := pixel.Bounds(im)
+	for r := skip; ; r++ {
+		p := start.Add(image.Point{
+			X: int(math.Round(float64(r) * dx)),
+			Y: int(math.Round(float64(r) * dy)),
+		})
+		if !p.In(b) {
+			return image.Point{}, false
+		}
+		v := int(pixel.UCharAt(im, p))
+		if prev >= 0 && v-prev > starburstThreshold {
+			return p, true
+		}
+		prev = v
+	}
+}
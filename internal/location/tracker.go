@@ -0,0 +1,283 @@
+package location
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/geometry/pixel"
+)
+
+const (
+	// motionThumbnail is the size of the thumbnails of the eye
+	// region that Tracker compares between frames. It only needs to
+	// show whether the eye moved, not where to.
+	motionThumbnail = 32
+
+	// trackHistory is the number of recent results that Tracker
+	// uses to judge whether a new result is consistent.
+	trackHistory = 5
+	// trackMinConfidence is the confidence below which Tracker
+	// considers it has lost the pupil.
+	trackMinConfidence = 0.5
+	// trackHysteresis is the number of consecutive consistent frames
+	// Tracker needs before narrowing its region of interest again.
+	// Without it, a marginal track would oscillate between narrow
+	// and wide searches every frame.
+	trackHysteresis = 3
+)
+
+// roiSizes are the half-sizes, in pupil radii, of the regions of
+// interest that Tracker searches, from narrowest to widest. Past the
+// last one, Tracker searches the full frame.
+var roiSizes = []int{3, 6}
+
+// Tracker locates the pupil in successive frames of a video, reusing
+// work from previous frames where it can. It is not safe for
+// concurrent use.
+type Tracker struct {
+	// Detector is used for every frame. nil selects a Detector with
+	// default options.
+	Detector *Detector
+
+	// MotionThreshold enables motion gating. If the eye region of a
+	// frame differs from the last fully analyzed frame by less than
+	// this mean absolute difference in brightness (0-255), the
+	// previous result is reused instead of localizing again. Zero
+	// disables motion gating.
+	MotionThreshold float64
+
+	// ROI enables region of interest tracking. Once the pupil has
+	// been found, later frames are only searched near where it
+	// was, which is much cheaper than searching the full frame.
+	//
+	// If a result is low confidence, or jumps inconsistently with
+	// recent motion, the search is retried on a wider region, and
+	// eventually the full frame. The region only narrows again after
+	// several consistent frames.
+	ROI bool
+
+	// history holds the last few confident results, oldest first.
+	history []PupilResult
+	// level is the current search region: an index into roiSizes,
+	// or len(roiSizes) for the full frame.
+	level int
+	// good is the number of consecutive consistent frames at the
+	// current level.
+	good int
+
+	// last is the last result that came from a full localization.
+	last PupilResult
+	// lastThumb is the eye region of the frame that produced last,
+	// or an empty Mat if there isn't one.
+	lastThumb gocv.Mat
+	// haveLast is true if last and lastThumb are valid.
+	haveLast bool
+}
+
+// Track locates the pupil in im, the next frame of the video.
+func (t *Tracker) Track(ctx context.Context, im gocv.Mat) (PupilResult, error) {
+	if t.Detector == nil {
+		t.Detector = NewDetector(nil)
+	}
+	thumb := t.eyeThumbnail(im)
+
+	if t.MotionThreshold > 0 && t.haveLast {
+		diff := gocv.NewMat()
+		gocv.AbsDiff(thumb, t.lastThumb, &diff)
+		motion := diff.Mean().Val1
+		diff.Close()
+		if motion < t.MotionThreshold {
+			thumb.Close()
+			r := t.last
+			r.Reused = true
+			return r, nil
+		}
+	}
+
+	r, err := t.search(ctx, im)
+	if err != nil {
+		thumb.Close()
+		return PupilResult{}, err
+	}
+
+	if t.haveLast {
+		t.lastThumb.Close()
+	}
+	t.last = r
+	t.haveLast = true
+	// Compare future frames against the eye region around the new
+	// pupil, which may have moved since thumb was taken.
+	if r.Pupil.R > 0 {
+		thumb.Close()
+		thumb = t.eyeThumbnail(im)
+	}
+	t.lastThumb = thumb
+	return r, nil
+}
+
+// Close releases the Tracker's resources. The Tracker can still be
+// used afterwards, but will not reuse anything from previous frames.
+func (t *Tracker) Close() {
+	if t.haveLast {
+		t.lastThumb.Close()
+	}
+	t.haveLast = false
+	t.history = nil
+	t.level = 0
+	t.good = 0
+}
+
+// search localizes the pupil in im, starting with the current region
+// of interest and widening it until the result is consistent with
+// the track so far.
+func (t *Tracker) search(ctx context.Context, im gocv.Mat) (PupilResult, error) {
+	level := len(roiSizes)
+	if t.ROI && len(t.history) > 0 {
+		level = t.level
+	}
+
+	var r PupilResult
+	for ; level <= len(roiSizes); level++ {
+		var err error
+		if r, err = t.localize(ctx, im, level); err != nil {
+			return PupilResult{}, err
+		}
+		if t.consistent(r) {
+			break
+		}
+		t.good = 0
+	}
+	if level > len(roiSizes) {
+		// Even the full frame search was inconsistent. Stay on the
+		// full frame until the track is reestablished.
+		level = len(roiSizes)
+	}
+	t.level = level
+
+	switch {
+	case t.consistent(r):
+		t.good++
+		if t.good >= trackHysteresis && t.level > 0 {
+			t.level--
+			t.good = 0
+		}
+	case r.Confidence >= trackMinConfidence:
+		// Confident, but far from where the track says the pupil
+		// should be. This was a full frame search, so believe it:
+		// the eye moved abruptly, or the track was wrong. Start
+		// over from here.
+		t.history = nil
+	}
+	if r.Confidence >= trackMinConfidence {
+		t.history = append(t.history, r)
+		if len(t.history) > trackHistory {
+			t.history = t.history[1:]
+		}
+	}
+	return r, nil
+}
+
+// localize searches for the pupil in im, in the region of interest
+// given by level.
+func (t *Tracker) localize(ctx context.Context, im gocv.Mat, level int) (PupilResult, error) {
+	if level >= len(roiSizes) || len(t.history) == 0 {
+		return t.Detector.Locate(ctx, im)
+	}
+
+	last := t.history[len(t.history)-1].Pupil
+	m := image.Point{roiSizes[level] * last.R, roiSizes[level] * last.R}
+	roi := image.Rectangle{Min: last.Point.Sub(m), Max: last.Point.Add(m)}.Intersect(pixel.Bounds(im))
+	if roi.Empty() {
+		return t.Detector.Locate(ctx, im)
+	}
+
+	// Pupil size changes slowly, so only look for pupils close to
+	// the last one's size. This also keeps the default radius range,
+	// which scales with the image height, from going wrong on a
+	// small crop.
+	fingerprint := t.Detector.Fingerprint()
+	opts := t.Detector.Options()
+	opts.MinPupilRadius = last.R * 2 / 3
+	opts.MaxPupilRadius = last.R*3/2 + 1
+
+	region := im.Region(roi)
+	defer region.Close()
+	r, err := LocatePupilContext(ctx, region, &opts)
+	if err != nil {
+		return PupilResult{}, err
+	}
+	r.Pupil.Point = r.Pupil.Point.Add(roi.Min)
+	r.Diagnostics.Coarse.Point = r.Diagnostics.Coarse.Point.Add(roi.Min)
+	for i := range r.Diagnostics.Attempts {
+		a := &r.Diagnostics.Attempts[i]
+		a.Pupil.Point = a.Pupil.Point.Add(roi.Min)
+	}
+	if r.Contour != nil {
+		r.Contour.Center = r.Contour.Center.Add(roi.Min)
+	}
+	// The narrowed radius range is the tracker's doing, not a
+	// different pipeline.
+	r.Fingerprint = fingerprint
+	return r, nil
+}
+
+// consistent reports whether r is a plausible continuation of the
+// track: confident, and not jumping further than recent motion
+// suggests it could.
+func (t *Tracker) consistent(r PupilResult) bool {
+	if r.Confidence < trackMinConfidence {
+		return false
+	}
+	if len(t.history) == 0 {
+		return true
+	}
+
+	last := t.history[len(t.history)-1].Pupil
+	// Allow 3x the average recent step, but never less than a
+	// quarter of the pupil radius, so that a still eye doesn't
+	// make every small saccade look like a failure.
+	var avgStep float64
+	for i := 1; i < len(t.history); i++ {
+		avgStep += dist(t.history[i].Pupil.Point, t.history[i-1].Pupil.Point)
+	}
+	if len(t.history) > 1 {
+		avgStep /= float64(len(t.history) - 1)
+	}
+	maxStep := math.Max(3*avgStep, float64(last.R)/4)
+	if dist(r.Pupil.Point, last.Point) > maxStep {
+		return false
+	}
+	dr := r.Pupil.R - last.R
+	return dr*4 <= last.R && -dr*4 <= last.R
+}
+
+// dist returns the distance between a and b.
+func dist(a, b image.Point) float64 {
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y))
+}
+
+// eyeThumbnail returns a small grayscale thumbnail of the region of im
+// around the last known pupil, or of all of im if there is none.
+func (t *Tracker) eyeThumbnail(im gocv.Mat) gocv.Mat {
+	b := pixel.Bounds(im)
+	roi := b
+	if t.haveLast && t.last.Pupil.R > 0 {
+		// Two more pupil radii beyond the pupil's edge covers
+		// most of the iris, which is where motion matters.
+		p := t.last.Pupil
+		m := image.Point{3 * p.R, 3 * p.R}
+		roi = image.Rectangle{Min: p.Point.Sub(m), Max: p.Point.Add(m)}.Intersect(b)
+		if roi.Empty() {
+			roi = b
+		}
+	}
+
+	region := im.Region(roi)
+	defer region.Close()
+	ret := gocv.NewMat()
+	gocv.Resize(region, &ret, image.Point{motionThumbnail, motionThumbnail}, 0, 0, gocv.InterpolationArea)
+	return ret
+}
@@ -0,0 +1,152 @@
+// Package model downloads, verifies and caches the model files used
+// by the neural network based localizers.
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Model is a model file that can be fetched from the network.
+type Model struct {
+	// URL is where to download the model from.
+	URL string
+	// SHA256 is the hex-encoded SHA-256 of the model file. Since it
+	// changes with every revision of the model, it doubles as the
+	// model's version.
+	SHA256 string
+}
+
+// Cache is a directory of downloaded, verified model files.
+type Cache struct {
+	// Dir is the cache directory. It is created if needed.
+	Dir string
+	// Offline, if true, prevents downloads. Models must already be
+	// in the cache.
+	Offline bool
+	// Client is the HTTP client used for downloads. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// DefaultDir returns the default cache directory, in the user's
+// cache directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "iris", "models"), nil
+}
+
+// Get returns the path to a verified local copy of m, downloading it
+// if needed.
+func (c *Cache) Get(m Model) (string, error) {
+	want := strings.ToLower(m.SHA256)
+	if _, err := hex.DecodeString(want); err != nil || len(want) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid SHA-256 %q for model %s", m.SHA256, m.URL)
+	}
+
+	// Files are named by their hash, so different versions of a
+	// model coexist, and the name alone says which version it is.
+	// Keep the URL's extension, OpenCV uses it to pick a model
+	// parser.
+	p := filepath.Join(c.Dir, want+path.Ext(m.URL))
+
+	if got, err := HashFile(p); err == nil {
+		if got == want {
+			return p, nil
+		}
+		// Corrupted somehow. Fall through and fetch it again.
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if c.Offline {
+		return "", fmt.Errorf("model %s is not in cache %s, and downloads are disabled", m.URL, c.Dir)
+	}
+	if err := c.download(m.URL, p, want); err != nil {
+		return "", fmt.Errorf("fetching model %s: %v", m.URL, err)
+	}
+	return p, nil
+}
+
+// download fetches url into dst, provided its SHA-256 matches
+// want. dst is only ever created with verified contents.
+func (c *Cache) download(url, dst, want string) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(c.Dir, "download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch, got %s want %s", got, want)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// HashFile returns the hex-encoded SHA-256 of the file at p.
+func HashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ErrNoModel is returned by Resolve when no model is configured.
+var ErrNoModel = errors.New("no model configured")
+
+// Resolve returns the path to the model to use. An explicit local
+// path always wins, and is used as-is without checksum verification,
+// for fully offline setups. Otherwise, m is fetched through the
+// cache.
+func (c *Cache) Resolve(localPath string, m Model) (string, error) {
+	if localPath != "" {
+		return localPath, nil
+	}
+	if m.URL == "" {
+		return "", ErrNoModel
+	}
+	return c.Get(m)
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// serveModel serves data at /model.onnx, and counts the requests.
+func serveModel(t *testing.T, data []byte) (url string, requests *int32) {
+	requests = new(int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.URL.Path != "/model.onnx" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/model.onnx", requests
+}
+
+func sha(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func TestCacheGet(t *testing.T) {
+	data := []byte("not really a network")
+	url, requests := serveModel(t, data)
+	c := &Cache{Dir: t.TempDir()}
+	m := Model{URL: url, SHA256: sha(data)}
+
+	p, err := c.Get(m)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got, want := filepath.Base(p), sha(data)+".onnx"; got != want {
+		t.Errorf("cached as %s, want %s", got, want)
+	}
+	got, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("cached model is %q, want %q", got, data)
+	}
+
+	// The second time, the model comes from the cache, even offline.
+	c.Offline = true
+	if p2, err := c.Get(m); err != nil || p2 != p {
+		t.Errorf("second Get = %q, %v, want %q, nil", p2, err, p)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestCacheGetRejectsBadChecksum(t *testing.T) {
+	url, _ := serveModel(t, []byte("tampered"))
+	c := &Cache{Dir: t.TempDir()}
+	m := Model{URL: url, SHA256: sha([]byte("original"))}
+
+	if _, err := c.Get(m); err == nil {
+		t.Fatal("Get succeeded with a bad checksum")
+	}
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Errorf("left %s behind in the cache", f.Name())
+	}
+}
+
+func TestCacheGetRedownloadsCorrupted(t *testing.T) {
+	data := []byte("not really a network")
+	url, requests := serveModel(t, data)
+	c := &Cache{Dir: t.TempDir()}
+	m := Model{URL: url, SHA256: sha(data)}
+
+	p, err := c.Get(m)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := ioutil.WriteFile(p, []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(m); err != nil {
+		t.Fatalf("Get after corruption: %v", err)
+	}
+	if got, err := HashFile(p); err != nil || got != sha(data) {
+		t.Errorf("HashFile after refetch = %s, %v, want %s", got, err, sha(data))
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestCacheGetOffline(t *testing.T) {
+	url, requests := serveModel(t, []byte("model"))
+	c := &Cache{Dir: t.TempDir(), Offline: true}
+	if _, err := c.Get(Model{URL: url, SHA256: sha([]byte("model"))}); err == nil {
+		t.Error("offline Get of an uncached model succeeded")
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Errorf("%d requests while offline, want 0", n)
+	}
+}
+
+func TestCacheGetInvalidSHA(t *testing.T) {
+	c := &Cache{Dir: t.TempDir()}
+	for _, s := range []string{"", "abc", sha(nil)[:62] + "zz"} {
+		if _, err := c.Get(Model{URL: "http://example.invalid/m.onnx", SHA256: s}); err == nil {
+			t.Errorf("Get with SHA-256 %q succeeded", s)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := &Cache{Dir: t.TempDir(), Offline: true}
+	if got, err := c.Resolve("/models/local.onnx", Model{}); err != nil || got != "/models/local.onnx" {
+		t.Errorf("Resolve(local) = %q, %v, want the local path", got, err)
+	}
+	if _, err := c.Resolve("", Model{}); !errors.Is(err, ErrNoModel) {
+		t.Errorf("Resolve with no model = %v, want ErrNoModel", err)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package shmframe
+
+import (
+	"os"
+	"syscall"
+)
+
+// Open maps the frame ring in the file at path, read only.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping outlives the file descriptor.
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReader(mem, func() error { return syscall.Munmap(mem) })
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+	return r, nil
+}
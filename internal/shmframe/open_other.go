@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package shmframe
+
+import "errors"
+
+// Open maps the frame ring in the file at path, read only. Shared
+// memory frame rings are only supported on Linux.
+func Open(path string) (*Reader, error) {
+	return nil, errors.New("shared memory frame rings are only supported on Linux")
+}
@@ -0,0 +1,154 @@
+//go:build opencv
+// +build opencv
+
+package shmframe
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// ring is a frame ring being written, for tests.
+type ring struct {
+	mem           []byte
+	width, height int
+	slots         int
+	stride        int
+}
+
+func newRing(width, height, slots int, fps float64) *ring {
+	stride := (slotHeader + width*height + 7) &^ 7
+	r := &ring{
+		mem:    make([]byte, headerSize+slots*stride),
+		width:  width,
+		height: height,
+		slots:  slots,
+		stride: stride,
+	}
+	le := binary.LittleEndian
+	copy(r.mem, magic)
+	le.PutUint32(r.mem[8:], uint32(width))
+	le.PutUint32(r.mem[12:], uint32(height))
+	le.PutUint32(r.mem[16:], uint32(slots))
+	le.PutUint64(r.mem[24:], math.Float64bits(fps))
+	return r
+}
+
+// publish writes frame seq, with every pixel set to seq.
+func (r *ring) publish(seq uint64) {
+	le := binary.LittleEndian
+	off := headerSize + int(seq%uint64(r.slots))*r.stride
+	le.PutUint64(r.mem[off:], 0)
+	for i := 0; i < r.width*r.height; i++ {
+		r.mem[off+slotHeader+i] = byte(seq)
+	}
+	le.PutUint64(r.mem[off+8:], seq*1e6)
+	le.PutUint64(r.mem[off:], seq)
+	le.PutUint64(r.mem[32:], seq)
+}
+
+func (r *ring) done() {
+	binary.LittleEndian.PutUint32(r.mem[20:], flagDone)
+}
+
+func TestReader(t *testing.T) {
+	w := newRing(8, 4, 3, 30)
+	rd, err := newReader(w.mem, func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rd.Width != 8 || rd.Height != 4 || rd.FPS != 30 {
+		t.Errorf("geometry %dx%d@%v, want 8x4@30", rd.Width, rd.Height, rd.FPS)
+	}
+
+	// Frames 1 and 2 fall more than a ring behind, and get skipped.
+	for seq := uint64(1); seq <= 5; seq++ {
+		w.publish(seq)
+	}
+	w.done()
+
+	ctx := context.Background()
+	for want := uint64(3); want <= 5; want++ {
+		f, err := rd.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if f.Seq != want {
+			t.Errorf("got frame %d, want %d", f.Seq, want)
+		}
+		if f.Gray.Rows() != 4 || f.Gray.Cols() != 8 {
+			t.Errorf("frame %d is %dx%d, want 8x4", f.Seq, f.Gray.Cols(), f.Gray.Rows())
+		}
+		if v := f.Gray.GetUCharAt(3, 7); v != byte(want) {
+			t.Errorf("frame %d has pixel %d, want %d", f.Seq, v, want)
+		}
+		f.Gray.Close()
+	}
+	if _, err := rd.Next(ctx); err != io.EOF {
+		t.Errorf("Next after the last frame = %v, want io.EOF", err)
+	}
+}
+
+func TestReaderWriterRestart(t *testing.T) {
+	w := newRing(8, 4, 3, 30)
+	rd, err := newReader(w.mem, func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	next := func(want uint64) {
+		t.Helper()
+		f, err := rd.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		f.Gray.Close()
+		if f.Seq != want {
+			t.Errorf("got frame %d, want %d", f.Seq, want)
+		}
+	}
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		w.publish(seq)
+		next(seq)
+	}
+
+	// A new writer takes over the buffer, and starts from 1.
+	w.publish(1)
+	w.publish(2)
+	w.done()
+	next(1)
+	next(2)
+	if _, err := rd.Next(ctx); err != io.EOF {
+		t.Errorf("Next after the last frame = %v, want io.EOF", err)
+	}
+}
+
+func TestNewReaderRejects(t *testing.T) {
+	if _, err := newReader([]byte("IRISSHM0 and some"), nil); err == nil {
+		t.Error("newReader accepted a bad magic")
+	}
+	w := newRing(8, 4, 3, 30)
+	if _, err := newReader(w.mem[:len(w.mem)-1], nil); err == nil {
+		t.Error("newReader accepted a truncated ring")
+	}
+
+	// Geometry whose size overflows if multiplied out naively.
+	le := binary.LittleEndian
+	huge := newRing(8, 4, 3, 30)
+	le.PutUint32(huge.mem[8:], math.MaxUint32)
+	le.PutUint32(huge.mem[12:], math.MaxUint32)
+	if _, err := newReader(huge.mem, nil); err == nil {
+		t.Error("newReader accepted a ring of 2^32x2^32 frames")
+	}
+	huge = newRing(8, 4, 3, 30)
+	le.PutUint32(huge.mem[8:], maxSide)
+	le.PutUint32(huge.mem[12:], maxSide)
+	le.PutUint32(huge.mem[16:], math.MaxUint32)
+	if _, err := newReader(huge.mem, nil); err == nil {
+		t.Error("newReader accepted a ring of 2^32 huge slots")
+	}
+}
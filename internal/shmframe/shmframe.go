@@ -0,0 +1,198 @@
+// Package shmframe reads grayscale frames that a capture daemon on
+// the same host publishes in a shared memory ring buffer, so that
+// frames don't get encoded and decoded on the way.
+//
+// The buffer is a file, typically in /dev/shm (POSIX shm) or a memfd
+// passed down as /proc/<pid>/fd/<n>, laid out as follows. All
+// integers are little endian.
+//
+//	offset  size  header
+//	0       8     magic, "IRISSHM1"
+//	8       4     frame width in pixels
+//	12      4     frame height in pixels
+//	16      4     number of slots in the ring
+//	20      4     flags: bit 0 is set once the writer is done
+//	24      8     nominal frame rate, float64 frames per second
+//	32      8     sequence number of the newest complete frame, 0 if none
+//	40      24    reserved, zero
+//
+// The header is followed by the slots, each 16 bytes of slot header
+// and width*height bytes of 8 bit pixels, row after row, padded to a
+// multiple of 8 bytes:
+//
+//	offset  size  slot header
+//	0       8     sequence number of the frame in the slot
+//	8       8     capture time, nanoseconds since the Unix epoch
+//
+// Frames are numbered from 1, and frame n goes in slot n % slots. To
+// publish a frame, the writer zeroes its slot's sequence number,
+// fills in the pixels and capture time, then sets the slot's sequence
+// number, and finally the header's newest sequence number. The
+// sequence numbers are 8 byte aligned, and written atomically.
+//
+// Readers never write to the buffer. A reader that falls more than
+// a ring behind skips ahead to the newest frames, and a frame that
+// gets overwritten while it's being read is skipped. A writer that
+// restarts numbering frames from 1 in the same buffer is followed.
+package shmframe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	magic      = "IRISSHM1"
+	headerSize = 64
+	slotHeader = 16
+	flagDone   = 1 << 0
+	// maxSide is the largest frame width or height accepted. It's
+	// far beyond any camera, and keeps the slot size computations
+	// from overflowing on hostile or corrupt headers.
+	maxSide = 1 << 16
+	// pollInterval is how often Next checks for a new frame. It's
+	// well under a frame time at the frame rates of iris cameras.
+	pollInterval = time.Millisecond
+)
+
+// A Frame is a frame read from the ring.
+type Frame struct {
+	// Seq is the frame's sequence number. Gaps mean dropped
+	// frames.
+	Seq uint64
+	// Time is when the frame was captured.
+	Time time.Time
+	// Gray is the frame's pixels. The receiver must close it.
+	Gray gocv.Mat
+}
+
+// Reader reads frames from a ring buffer.
+type Reader struct {
+	mem []byte
+	// Width, Height and FPS are the frame size and nominal frame
+	// rate declared by the writer.
+	Width, Height int
+	FPS           float64
+	slots         uint64
+	stride        int
+	// last is the sequence number of the last frame returned.
+	last uint64
+	// close releases mem.
+	close func() error
+}
+
+// newReader returns a Reader over mem, after checking its header.
+func newReader(mem []byte, close func() error) (*Reader, error) {
+	if len(mem) < headerSize || string(mem[:len(magic)]) != magic {
+		return nil, errors.New("not an iris frame ring")
+	}
+	le := binary.LittleEndian
+	w, h := uint64(le.Uint32(mem[8:])), uint64(le.Uint32(mem[12:]))
+	slots := uint64(le.Uint32(mem[16:]))
+	if w == 0 || h == 0 || w > maxSide || h > maxSide || slots == 0 {
+		return nil, fmt.Errorf("invalid frame ring geometry %dx%d, %d slots", w, h, slots)
+	}
+	// The header's numbers come from another process, so check that
+	// the slots fit without multiplying them out, which could
+	// overflow.
+	stride := (slotHeader + w*h + 7) &^ 7
+	if (uint64(len(mem))-headerSize)/stride < slots {
+		return nil, fmt.Errorf("frame ring is %d bytes, too small for %d slots of %d bytes", len(mem), slots, stride)
+	}
+	return &Reader{
+		mem:    mem,
+		Width:  int(w),
+		Height: int(h),
+		FPS:    math.Float64frombits(le.Uint64(mem[24:])),
+		slots:  slots,
+		stride: int(stride),
+		close:  close,
+	}, nil
+}
+
+// Close releases the ring buffer. It doesn't affect the writer.
+func (r *Reader) Close() error {
+	return r.close()
+}
+
+// uint64At atomically loads the 8 byte aligned integer at off.
+func (r *Reader) uint64At(off int) uint64 {
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.mem[off])))
+}
+
+// Next returns the next frame, waiting for the writer if needed. It
+// returns io.EOF once the writer is done and every frame it wrote
+// since the reader fell behind has been returned.
+func (r *Reader) Next(ctx context.Context) (Frame, error) {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		// Check for the end first: the writer sets the flag after
+		// publishing its last frame, so that frame is then visible
+		// below.
+		done := atomic.LoadUint32((*uint32)(unsafe.Pointer(&r.mem[20])))&flagDone != 0
+		newest := r.uint64At(32)
+		if newest < r.last {
+			// The writer restarted, and numbers frames from 1
+			// again. Start over with it.
+			r.last = 0
+		}
+		if newest > r.last {
+			next := r.last + 1
+			// Frames more than a ring behind are gone.
+			if newest-next >= r.slots {
+				next = newest - r.slots + 1
+			}
+			if f, ok := r.read(next); ok {
+				r.last = next
+				return f, nil
+			}
+			// Overwritten under us. Skip it, the writer is ahead.
+			r.last = next
+			continue
+		}
+		if done {
+			return Frame{}, io.EOF
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return Frame{}, ctx.Err()
+		}
+	}
+}
+
+// read copies frame seq out of its slot. ok is false if the slot
+// doesn't hold seq, or the writer overwrote it during the copy.
+func (r *Reader) read(seq uint64) (f Frame, ok bool) {
+	off := headerSize + int(seq%r.slots)*r.stride
+	if r.uint64At(off) != seq {
+		return Frame{}, false
+	}
+	n := r.Width * r.Height
+
+	// The Mat wraps the slot in place, without copying it, and the
+	// clone is the frame's one and only copy. The slot stays mapped
+	// for as long as the Reader is open, so it outlives the wrapper.
+	wrapped, err := gocv.NewMatFromBytes(r.Height, r.Width, gocv.MatTypeCV8U, r.mem[off+slotHeader:off+slotHeader+n])
+	if err != nil {
+		return Frame{}, false
+	}
+	gray := wrapped.Clone()
+	wrapped.Close()
+	ts := int64(binary.LittleEndian.Uint64(r.mem[off+8:]))
+	if r.uint64At(off) != seq {
+		gray.Close()
+		return Frame{}, false
+	}
+	return Frame{Seq: seq, Time: time.Unix(0, ts), Gray: gray}, true
+}
@@ -1,24 +1,387 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"math"
 	"os"
+	"strings"
 
 	"gocv.io/x/gocv"
 
+	"go.universe.tf/iris/internal/debug"
+	"go.universe.tf/iris/internal/errs"
 	"go.universe.tf/iris/internal/location"
+	"go.universe.tf/iris/internal/model"
 )
 
+var (
+	profile     = flag.String("profile", "default", "tuning preset to start from (default, embedded)")
+	fitter      = flag.String("fitter", "", "pupil fitting algorithm to use (hough, ransac, frst, cnn), overriding the profile")
+	minRadius   = flag.Int("min-radius", 0, "smallest pupil radius to look for, in pixels (0 for the profile's choice)")
+	maxRadius   = flag.Int("max-radius", 0, "largest pupil radius to look for, in pixels (0 for the profile's choice)")
+	noSmooth    = flag.Bool("no-smooth", false, "don't smooth the Hough accumulator before picking peaks")
+	closeEdges  = flag.Bool("close-edges", false, "bridge gaps in broken pupil edges before fitting, at some cost in precision")
+	edgeCombine = flag.String("edge-combine", "and", "how to merge the two pupil edge maps (and, product, min)")
+	thinEdges   = flag.Bool("thin-edges", false, "thin pupil edges to one pixel before fitting")
+	darkFrac    = flag.Float64("dark-fraction", 0, "fraction of the image that may be pupil dark (0 for a fixed darkness level)")
+	edgeBlur    = flag.Int("edge-blur", 0, "Gaussian blur size before edge detection, in pixels (0 to pick one per image)")
+	openKernel  = flag.Int("open-kernel", 0, "size of the opening that removes noise from dark regions, in pixels (0 for the default)")
+	modelPath   = flag.String("model-path", "", "local segmentation model file for -fitter=cnn, overriding -model-url")
+	modelURL    = flag.String("model-url", "", "URL to download the segmentation model for -fitter=cnn from")
+	modelSHA256 = flag.String("model-sha256", "", "expected SHA-256 of the model at -model-url")
+	modelCache  = flag.String("model-cache", "", "directory to cache downloaded models in (default: user cache directory)")
+	offline     = flag.Bool("offline", false, "never download models, only use cached or local ones")
+	fallback    = flag.String("fallback", "", "comma-separated fitters to try when -fitter isn't confident enough")
+	retries     = flag.String("retry", "", "comma-separated alternate parameter sets to retry with when no fitter is confident enough (wider-radii, close-edges, soft-edges, cnn)")
+	minConf     = flag.Float64("min-confidence", 0, "confidence below which -fallback fitters get tried (0 for the default)")
+	accel       = flag.String("accelerator", "cpu", "hardware to run the segmentation model on (cpu, opencl, cuda), falling back to cpu if unavailable")
+	parallelism = flag.Int("parallelism", 0, "goroutines to spread Hough voting over (0 for one per CPU)")
+	timeout     = flag.Duration("timeout", 0, "give up on localization after this long (0 for no limit)")
+	selfTest    = flag.Bool("self-test", false, "check the configured pipeline on a synthetic image, then exit")
+	normalized  = flag.Bool("normalized", false, "also print the pupil in normalized [0,1] image coordinates")
+	gaze        = flag.Bool("gaze", false, "estimate and print the gaze direction")
+	contour     = flag.Bool("contour", false, "trace the pupil boundary, and print how far it strays from the fitted circle")
+	pxPerMM     = flag.Float64("px-per-mm", 0, "image scale at the eye, for reporting sizes in millimeters")
+	irisCal     = flag.Bool("calibrate-iris", false, "estimate -px-per-mm from the segmented iris, assuming an average iris size (needs a model)")
+	showStrip   = flag.Bool("show-strip", false, "show the unwrapped iris strip, with the CNN's noise mask if a model is available")
+)
+
+// subcommands are the CLI's modes other than localizing a single
+// image. They share the localization flags, and add their own.
+var subcommands = map[string]func(location.Options) error{
+	"pupillometry": pupillometry,
+	"bench":        bench,
+	"compare":      compare,
+	"fit":          fit,
+	"redact":       redact,
+	"tune":         tune,
+}
+
 func main() {
-	im := gocv.IMRead(os.Args[1], gocv.IMReadGrayScale)
+	cmd := localize
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		cmd = subcommands[os.Args[1]]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	opts, err := optionsFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop, err := startProfiling()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = cmd(opts)
+	if perr := stop(); perr != nil {
+		log.Printf("writing profiles: %v", perr)
+	}
+	if err != nil {
+		log.Print(err)
+		os.Exit(errs.ExitCode(err))
+	}
+}
+
+// localize locates the pupil in the image named on the command line,
+// and prints what it found.
+func localize(opts location.Options) error {
+	if *selfTest {
+		if err := location.SelfTest(context.Background(), &opts); err != nil {
+			return err
+		}
+		fmt.Println("self-test OK")
+		return nil
+	}
+
+	im := gocv.IMRead(flag.Arg(0), gocv.IMReadGrayScale)
 	defer im.Close()
+	if im.Empty() {
+		return errs.New(errs.ErrUnsupportedFormat, "can't read image %q", flag.Arg(0))
+	}
 
-	_, p := location.FindPupil(im)
-	location.FindSclera(im, p)
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	det := location.NewDetector(&opts)
+	if _, err := det.Accelerator(); err != nil {
+		log.Printf("using cpu instead of %s: %v", opts.Accelerator, err)
+	}
+	res, err := det.Locate(ctx, im)
+	if err != nil {
+		return fmt.Errorf("locating pupil: %w", err)
+	}
+	if res.Pupil.R == 0 {
+		kind := errs.ErrNotFound
+		if lowQuality(res.Reasons) {
+			kind = errs.ErrLowQuality
+		}
+		return errs.New(kind, "no pupil found in %q (reasons: %s)", flag.Arg(0), reasonList(res.Reasons))
+	}
+	fmt.Printf("pupil %s found by %s, confidence %.2f", res.Pupil, res.Fitter, res.Confidence)
+	if res.Fitter == location.FitCNN {
+		fmt.Printf(" on %s", res.Accelerator)
+	}
+	if res.Fallback {
+		fmt.Print(" (fallback)")
+	}
+	if res.Retry != location.RetryNone {
+		fmt.Printf(" (retry %s)", res.Retry)
+	}
+	if res.Degraded {
+		fmt.Print(" (refinement failed, approximate only)")
+	}
+	fmt.Println()
+	fmt.Printf("pipeline %s\n", res.Fingerprint)
+	if len(res.Reasons) > 0 {
+		fmt.Printf("low confidence reasons %s\n", reasonList(res.Reasons))
+	}
+	if cal := calibrate(im, opts.ModelPath); cal.Calibrated() {
+		fmt.Printf("pupil diameter %.2fmm\n", cal.MM(float64(2*res.Pupil.R)))
+	}
+	if res.Gaze != nil {
+		fmt.Printf("gaze %s\n", res.Gaze)
+	}
+	if res.Contour != nil {
+		lo, hi := res.Contour.R[0], res.Contour.R[0]
+		for _, r := range res.Contour.R {
+			lo, hi = math.Min(lo, r), math.Max(hi, r)
+		}
+		fmt.Printf("pupil contour radius %.0f-%.0fpx\n", lo, hi)
+	}
+	if *normalized {
+		b := image.Rect(0, 0, im.Cols(), im.Rows())
+		fmt.Printf("normalized pupil %s\n", res.Pupil.Normalized(b))
+	}
+	if *showStrip {
+		showIrisStrip(im, res.Pupil, opts.ModelPath)
+	}
+	location.FindSclera(im, res.Pupil)
 
 	// gocv.CvtColor(im, &im, gocv.ColorGrayToBGR)
-	// im2 := im.Clone()
-	// gocv.Circle(&im, appx.Point, appx.R, color.RGBA{255, 0, 0, 255}, 2)
-	// gocv.Circle(&im2, p.Point, p.R, color.RGBA{0, 255, 0, 255}, 2)
+	// gocv.Circle(&im, res.Pupil.Point, res.Pupil.R, color.RGBA{0, 255, 0, 255}, 2)
+
+	// debug.ShowMats(im)
+
+	return nil
+}
+
+// optionsFromFlags returns the localization options selected by
+// command line flags.
+func optionsFromFlags() (location.Options, error) {
+	opts, err := location.Profile(*profile)
+	if err != nil {
+		return location.Options{}, err
+	}
+	if *fitter != "" {
+		f, err := location.ParseFitter(*fitter)
+		if err != nil {
+			return location.Options{}, err
+		}
+		opts.Fitter = f
+	}
+	if *minRadius != 0 {
+		opts.MinPupilRadius = *minRadius
+	}
+	if *maxRadius != 0 {
+		opts.MaxPupilRadius = *maxRadius
+	}
+	if *noSmooth {
+		opts.NoAccumulatorSmoothing = true
+	}
+	if *closeEdges {
+		opts.CloseEdgeGaps = true
+	}
+	if *thinEdges {
+		opts.ThinEdges = true
+	}
+	if *darkFrac != 0 {
+		opts.DarkFraction = *darkFrac
+	}
+	if *edgeBlur != 0 {
+		opts.EdgeBlur = *edgeBlur
+	}
+	if *openKernel != 0 {
+		opts.OpenKernel = *openKernel
+	}
+	if opts.EdgeCombination, err = location.ParseEdgeCombination(*edgeCombine); err != nil {
+		return location.Options{}, err
+	}
+	if *fallback != "" {
+		opts.Fallback = nil
+		for _, name := range strings.Split(*fallback, ",") {
+			f, err := location.ParseFitter(name)
+			if err != nil {
+				return location.Options{}, err
+			}
+			opts.Fallback = append(opts.Fallback, f)
+		}
+	}
+	if *retries != "" {
+		opts.Retries = nil
+		for _, name := range strings.Split(*retries, ",") {
+			r, err := location.ParseRetry(name)
+			if err != nil {
+				return location.Options{}, err
+			}
+			opts.Retries = append(opts.Retries, r)
+		}
+	}
+	if *minConf != 0 {
+		opts.MinConfidence = *minConf
+	}
+	if *gaze {
+		opts.EstimateGaze = true
+	}
+	if *contour {
+		opts.TraceContour = true
+	}
+	if *parallelism != 0 {
+		opts.Parallelism = *parallelism
+	}
+	if opts.Accelerator, err = location.ParseAccelerator(*accel); err != nil {
+		return location.Options{}, err
+	}
+
+	// The CNN retry is skipped without a model, so it only needs one
+	// resolved if one is configured.
+	cnnRetry := usesRetry(opts, location.RetryCNN) && (*modelPath != "" || *modelURL != "")
+	if usesFitter(opts, location.FitCNN) || cnnRetry || *irisCal {
+		cache := &model.Cache{
+			Dir:     *modelCache,
+			Offline: *offline,
+		}
+		if cache.Dir == "" {
+			if cache.Dir, err = model.DefaultDir(); err != nil {
+				return location.Options{}, err
+			}
+		}
+		m := model.Model{URL: *modelURL, SHA256: *modelSHA256}
+		if opts.ModelPath, err = cache.Resolve(*modelPath, m); err != nil {
+			return location.Options{}, err
+		}
+	}
+
+	return opts, nil
+}
+
+// showIrisStrip displays the iris around pupil unwrapped into polar
+// coordinates, with occluded pixels highlighted. With a segmentation
+// model, the strip extends to the segmented limbus, and the model
+// decides what's occluded. Without, it extends to the limbus found by
+// FindLimbus, or failing that, the largest plausible iris, and only
+// the eyelids count as occlusions.
+func showIrisStrip(im gocv.Mat, pupil location.Circle, modelPath string) {
+	// Same guess as FindSclera: the iris is at most 3.5x the size
+	// of the pupil.
+	outer := int(float64(pupil.R) * 3.5)
+	noise := gocv.NewMat()
+	if limbus, err := location.FindLimbus(im, pupil); err != nil {
+		log.Printf("finding limbus for iris strip: %v", err)
+	} else if limbus.Contrast < location.MinLimbusContrast || limbus.Circle.R <= pupil.R {
+		log.Printf("limbus %s is implausible or faint (contrast %.2f), using the largest plausible iris", limbus.Circle, limbus.Contrast)
+	} else {
+		outer = limbus.Circle.R
+		if upper, lower, err := location.FindEyelids(im, pupil, limbus.Circle); err == nil {
+			noise.Close()
+			noise = location.EyelidNoise(image.Rect(0, 0, im.Cols(), im.Rows()), upper, lower)
+		}
+	}
+	defer noise.Close()
+
+	if modelPath != "" {
+		seg, err := location.Segment(im, modelPath)
+		if err != nil {
+			log.Printf("segmenting for iris strip: %v", err)
+		} else {
+			defer seg.Close()
+			if limbus := seg.Limbus(); limbus.R > pupil.R {
+				outer = limbus.R
+			}
+			noise.Close()
+			noise = seg.Noise()
+		}
+	}
+
+	strip := debug.IrisStrip(im, pupil.Point, pupil.R, outer, noise)
+	defer strip.Close()
+	debug.ShowMats(strip)
+}
+
+// calibrate returns the image scale requested by flags, if any.
+// -px-per-mm wins. Otherwise, with -calibrate-iris, im gets segmented
+// with the model at modelPath to measure the iris.
+func calibrate(im gocv.Mat, modelPath string) location.Calibration {
+	if *pxPerMM > 0 {
+		return location.Calibration{PixelsPerMM: *pxPerMM}
+	}
+	if !*irisCal || modelPath == "" {
+		return location.Calibration{}
+	}
+	seg, err := location.Segment(im, modelPath)
+	if err != nil {
+		log.Printf("segmenting for calibration: %v", err)
+		return location.Calibration{}
+	}
+	defer seg.Close()
+	cal, err := location.CalibrateFromIris(seg.Limbus())
+	if err != nil {
+		return location.Calibration{}
+	}
+	return cal
+}
+
+// reasonList formats rs as a comma-separated list of their names, for
+// scripts to split.
+func reasonList(rs []location.Reason) string {
+	if len(rs) == 0 {
+		return "unknown"
+	}
+	var names []string
+	for _, r := range rs {
+		names = append(names, r.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// lowQuality reports whether any of rs blames the image itself.
+func lowQuality(rs []location.Reason) bool {
+	for _, r := range rs {
+		if r.Quality() {
+			return true
+		}
+	}
+	return false
+}
+
+// usesFitter reports whether opts might run fitter f.
+func usesFitter(opts location.Options, f location.Fitter) bool {
+	if opts.Fitter == f {
+		return true
+	}
+	for _, fb := range opts.Fallback {
+		if fb == f {
+			return true
+		}
+	}
+	return false
+}
 
-	// debug.ShowMats(im, im2)
+// usesRetry reports whether opts might retry with r.
+func usesRetry(opts location.Options, r location.Retry) bool {
+	for _, rt := range opts.Retries {
+		if rt == r {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/location"
+)
+
+// previewBoundary separates frames in the MJPEG stream.
+const previewBoundary = "irisframe"
+
+// preview serves the latest annotated frame over HTTP as an MJPEG
+// stream, which browsers show as live video.
+type preview struct {
+	mu sync.Mutex
+	// frame is the latest frame, JPEG encoded.
+	frame []byte
+	// updated is closed when frame changes, and then replaced.
+	updated chan struct{}
+	// clients is the number of streams being served. Frames are
+	// only annotated and encoded while it's non-zero.
+	clients int
+}
+
+// startPreview serves n previews on addr, in a new goroutine: the
+// first at /, and the others at /2, /3 and so on.
+func startPreview(addr string, n int) ([]*preview, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	ret := make([]*preview, n)
+	for i := range ret {
+		ret[i] = &preview{updated: make(chan struct{})}
+		path := "/"
+		if i > 0 {
+			path = fmt.Sprintf("/%d", i+1)
+		}
+		mux.Handle(path, ret[i])
+		log.Printf("serving preview on http://%s%s", l.Addr(), path)
+	}
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Printf("serving preview: %v", err)
+		}
+	}()
+	return ret, nil
+}
+
+// publish makes gray, annotated with res, the latest frame.
+func (p *preview) publish(gray gocv.Mat, res location.PupilResult) {
+	p.mu.Lock()
+	watched := p.clients > 0
+	p.mu.Unlock()
+	if !watched {
+		return
+	}
+
+	im := gocv.NewMat()
+	defer im.Close()
+	gocv.CvtColor(gray, &im, gocv.ColorGrayToBGR)
+	c := color.RGBA{0, 255, 0, 0}
+	if res.Confidence < *blinkConfidence {
+		c = color.RGBA{255, 0, 0, 0}
+	}
+	text := "no pupil"
+	if res.Pupil.R > 0 {
+		gocv.Circle(&im, res.Pupil.Point, res.Pupil.R, c, 2)
+		text = fmt.Sprintf("d %dpx conf %.2f", 2*res.Pupil.R, res.Confidence)
+	}
+	gocv.PutText(&im, text, image.Point{10, 30}, gocv.FontHersheySimplex, 0.8, c, 2)
+
+	jpg, err := gocv.IMEncode(gocv.JPEGFileExt, im)
+	if err != nil {
+		log.Printf("encoding preview frame: %v", err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.frame = jpg
+	close(p.updated)
+	p.updated = make(chan struct{})
+}
+
+// ServeHTTP streams frames to the client as they're published, until
+// it goes away. Frames published faster than the client reads them
+// are dropped, so a slow client never holds up measurement.
+func (p *preview) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.clients++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.clients--
+		p.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+previewBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	for {
+		p.mu.Lock()
+		frame, updated := p.frame, p.updated
+		p.mu.Unlock()
+		if frame != nil {
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", previewBoundary, len(frame)); err != nil {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
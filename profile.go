@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var (
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of the command to this file")
+	memProfile = flag.String("memprofile", "", "write a heap profile to this file when the command finishes")
+)
+
+// startProfiling starts the profiles requested by flags. The returned
+// function stops them and writes them out.
+//
+// Profiles cover the command itself, not flag parsing or model
+// downloads, so that they're directly useful in performance bug
+// reports.
+func startProfiling() (stop func() error, err error) {
+	var cpu *os.File
+	if *cpuProfile != "" {
+		if cpu, err = os.Create(*cpuProfile); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+
+	return func() error {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				return err
+			}
+		}
+		if *memProfile != "" {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			// Get up to date statistics, rather than as of the
+			// last GC.
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				return err
+			}
+			return f.Close()
+		}
+		return nil
+	}, nil
+}
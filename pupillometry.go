@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/location"
+	"go.universe.tf/iris/internal/shmframe"
+)
+
+var (
+	video           = flag.String("video", "", "pupillometry: video file to measure the pupil in")
+	shm             = flag.String("shm", "", "pupillometry: shared memory frame ring to measure the pupil in, instead of -video")
+	camera          = flag.Int("camera", -1, "pupillometry: camera device number to measure the pupil from, instead of -video")
+	cameraFormat    = flag.String("camera-format", "auto", "pupillometry: pixel format to ask -camera for (auto, gray, yuyv, mjpeg)")
+	out             = flag.String("out", "", "pupillometry: CSV file to write the pupil diameter series to (default: stdout)")
+	motionThreshold = flag.Float64("motion-threshold", 0, "pupillometry: reuse the previous frame's result when the eye region changed by less than this mean brightness difference (0 to disable)")
+	roi             = flag.Bool("roi", false, "pupillometry: only search near the last pupil, widening the search when the track is lost")
+	previewAddr     = flag.String("preview-addr", "", "pupillometry: address to serve an MJPEG preview of the annotated frames on, e.g. localhost:8080 (the other eye's is at /2)")
+	blinkConfidence = flag.Float64("blink-confidence", 0.5, "pupillometry: frames whose pupil confidence is below this are treated as blinks")
+)
+
+// pupilSample is one frame's pupil measurement.
+type pupilSample struct {
+	// Time is the frame's timestamp, in seconds from the start of
+	// capture.
+	Time float64
+	// Diameter is the pupil diameter in pixels, or 0 if the pupil
+	// wasn't visible.
+	Diameter float64
+	// Interpolated is true if Diameter was filled in from
+	// neighboring frames.
+	Interpolated bool
+}
+
+// pipelineDepth is the number of frames that can queue up between
+// pupillometry's stages. A few frames are enough to smooth out jitter
+// in per-frame costs, without holding much of the video in memory.
+const pipelineDepth = 4
+
+// pupillometry measures the pupil diameter in every frame of -video,
+// -camera, or the frame ring -shm that a capture daemon on the same
+// host writes to, and writes the series as CSV to -out. Rigs with a
+// camera per eye give the other eye's source in -video2, -camera2 or
+// -shm2, and get both series side by side. With -preview-addr, the
+// other eye's preview is served at /2.
+//
+// Decoding and localization run concurrently, in separate goroutines
+// connected by bounded channels. Throughput is then set by the slower
+// of the two, rather than their sum.
+func pupillometry(opts location.Options) error {
+	if *cameraFormat != "auto" && *camera < 0 && *camera2 < 0 {
+		return errors.New("-camera-format needs -camera or -camera2")
+	}
+	src, err := openSource(*video, *shm, *camera)
+	if err == errNoSource {
+		return errors.New("pupillometry needs one of -video, -shm or -camera")
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	src2, err := openSource(*video2, *shm2, *camera2)
+	switch {
+	case err == errNoSource:
+		src2 = nil
+	case err != nil:
+		return err
+	default:
+		defer src2.Close()
+	}
+
+	// One preview per eye, or none.
+	prev := make([]*preview, 2)
+	if *previewAddr != "" {
+		n := 1
+		if src2 != nil {
+			n = 2
+		}
+		ps, err := startPreview(*previewAddr, n)
+		if err != nil {
+			return err
+		}
+		copy(prev, ps)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Both eyes' timestamps count from the same instant, so that
+	// their frames can be matched up.
+	start := time.Now()
+	var cal location.Calibration
+	samples, errc := measureFrames(ctx, cancel, src.start(ctx, start), opts, &cal, prev[0])
+	if src2 == nil {
+		series, err := collectSeries(samples, errc)
+		if err != nil {
+			return err
+		}
+		return writeSeries(series, cal)
+	}
+
+	// Each eye gets its own pipeline, with its own tracking state
+	// and calibration, since the cameras see different eyes from
+	// different positions.
+	var (
+		cal2    location.Calibration
+		series2 []pupilSample
+		err2    error
+		done    = make(chan struct{})
+	)
+	samples2, errc2 := measureFrames(ctx, cancel, src2.start(ctx, start), opts, &cal2, prev[1])
+	go func() {
+		defer close(done)
+		series2, err2 = collectSeries(samples2, errc2)
+	}()
+	series, err := collectSeries(samples, errc)
+	<-done
+	if err != nil {
+		return err
+	}
+	if err2 != nil {
+		return err2
+	}
+	return writeStereoSeries(series, series2, cal, cal2, pairTolerance(src.FPS, src2.FPS))
+}
+
+// collectSeries gathers the output of measureFrames into a series,
+// with blinks interpolated.
+func collectSeries(samples <-chan pupilSample, errc <-chan error) ([]pupilSample, error) {
+	var series []pupilSample
+	for s := range samples {
+		series = append(series, s)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	interpolateBlinks(series)
+	return series, nil
+}
+
+// videoFrame is a decoded video frame.
+type videoFrame struct {
+	// Index is the frame's position in the video.
+	Index int
+	// Time is when the frame was captured, in seconds from the
+	// start of capture.
+	Time float64
+	// Gray is the frame in grayscale. The receiver must close it.
+	Gray gocv.Mat
+}
+
+// decodeFrames reads frames from vc in a new goroutine, and sends
+// them in grayscale on the returned channel, timestamped by stamp
+// from their index. The channel is closed at the end of the video, or
+// when ctx is done.
+func decodeFrames(ctx context.Context, vc *gocv.VideoCapture, stamp func(int) float64) <-chan videoFrame {
+	ret := make(chan videoFrame, pipelineDepth)
+	go func() {
+		defer close(ret)
+		frame := gocv.NewMat()
+		defer frame.Close()
+		for i := 0; vc.Read(&frame); i++ {
+			if frame.Empty() {
+				continue
+			}
+			gray, err := grayFrame(frame)
+			if err != nil {
+				log.Printf("skipping frame %d: %v", i, err)
+				continue
+			}
+			select {
+			case ret <- videoFrame{i, stamp(i), gray}:
+			case <-ctx.Done():
+				gray.Close()
+				return
+			}
+		}
+	}()
+	return ret
+}
+
+// readRing reads frames from r in a new goroutine, like decodeFrames.
+// Frames are indexed from the first one read, and frames the reader
+// fell too far behind on are skipped, leaving gaps in the indices.
+// Timestamps are the writer's capture times, relative to start. The
+// channel is closed when the writer is done, or when ctx is done.
+func readRing(ctx context.Context, r *shmframe.Reader, start time.Time) <-chan videoFrame {
+	ret := make(chan videoFrame, pipelineDepth)
+	go func() {
+		defer close(ret)
+		var first uint64
+		for {
+			f, err := r.Next(ctx)
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Printf("reading frame ring: %v", err)
+				}
+				return
+			}
+			if first == 0 {
+				first = f.Seq
+			}
+			select {
+			case ret <- videoFrame{int(f.Seq - first), f.Time.Sub(start).Seconds(), f.Gray}:
+			case <-ctx.Done():
+				f.Gray.Close()
+				return
+			}
+		}
+	}()
+	return ret
+}
+
+// measureFrames locates the pupil in frames in a new goroutine, and
+// sends a sample per frame on the returned channel, which is closed
+// once frames is exhausted. On failure, it cancels the pipeline and
+// sends the error on the returned error channel. Otherwise it sends
+// nil.
+//
+// The first frame that allows calibration sets *cal, which is safe to
+// read once the samples channel is closed. If prev isn't nil, every
+// frame is also published to it.
+func measureFrames(ctx context.Context, cancel context.CancelFunc, frames <-chan videoFrame, opts location.Options, cal *location.Calibration, prev *preview) (<-chan pupilSample, <-chan error) {
+	ret := make(chan pupilSample, pipelineDepth)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ret)
+		det := location.NewDetector(&opts)
+		if _, err := det.Accelerator(); err != nil {
+			log.Printf("using cpu instead of %s: %v", opts.Accelerator, err)
+		}
+		// The CSV has nowhere to record it, so log the pipeline
+		// fingerprint for comparing series from different runs.
+		log.Printf("pipeline %s", det.Fingerprint())
+		tracker := &location.Tracker{
+			Detector:        det,
+			MotionThreshold: *motionThreshold,
+			ROI:             *roi,
+		}
+		defer tracker.Close()
+		for f := range frames {
+			s, err := measureFrame(ctx, tracker, f, cal, prev)
+			f.Gray.Close()
+			if err != nil {
+				cancel()
+				// Release whatever the decoder had queued up.
+				for f := range frames {
+					f.Gray.Close()
+				}
+				errc <- err
+				return
+			}
+			ret <- s
+		}
+		errc <- nil
+	}()
+	return ret, errc
+}
+
+// measureFrame measures the pupil in f, and publishes the result to
+// prev, if not nil.
+func measureFrame(ctx context.Context, tracker *location.Tracker, f videoFrame, cal *location.Calibration, prev *preview) (pupilSample, error) {
+	// The camera and subject are assumed not to move much over a
+	// session, so one calibration serves the whole video. Keep
+	// trying until a frame yields one, in case the video starts
+	// mid-blink.
+	if !cal.Calibrated() {
+		*cal = calibrate(f.Gray, tracker.Detector.Options().ModelPath)
+	}
+	res, err := tracker.Track(ctx, f.Gray)
+	if err != nil {
+		return pupilSample{}, err
+	}
+	if prev != nil {
+		prev.publish(f.Gray, res)
+	}
+	s := pupilSample{Time: f.Time}
+	if res.Pupil.R > 0 && res.Confidence >= *blinkConfidence {
+		s.Diameter = float64(2 * res.Pupil.R)
+	}
+	return s, nil
+}
+
+// interpolateBlinks fills in the diameter of frames where the pupil
+// wasn't visible, by linear interpolation between the closest visible
+// frames on either side. Gaps at the start or end of the series have
+// nothing to interpolate from, and stay empty.
+func interpolateBlinks(series []pupilSample) {
+	last := -1
+	for i, s := range series {
+		if s.Diameter == 0 {
+			continue
+		}
+		if last >= 0 && i-last > 1 {
+			from, to := series[last], series[i]
+			for j := last + 1; j < i; j++ {
+				f := (series[j].Time - from.Time) / (to.Time - from.Time)
+				series[j].Diameter = from.Diameter + f*(to.Diameter-from.Diameter)
+				series[j].Interpolated = true
+			}
+		}
+		last = i
+	}
+}
+
+// writeSeries writes series as CSV to -out, or stdout.
+func writeSeries(series []pupilSample, cal location.Calibration) error {
+	var rows [][]string
+	for i := range series {
+		rows = append(rows, append([]string{formatTime(series[i].Time)}, formatSample(&series[i], cal)...))
+	}
+	return writeCSV(append([]string{"time_s"}, sampleColumns("")...), rows)
+}
+
+// writeCSV writes a CSV table to -out, or stdout.
+func writeCSV(header []string, rows [][]string) error {
+	f := os.Stdout
+	if *out != "" {
+		var err error
+		if f, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	w := csv.NewWriter(f)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if *out != "" {
+		return f.Close()
+	}
+	return nil
+}
+
+// formatTime formats a sample time for CSV output.
+func formatTime(t float64) string {
+	return strconv.FormatFloat(t, 'f', 3, 64)
+}
+
+// sampleColumns returns the CSV column names for a sample, with the
+// given prefix.
+func sampleColumns(prefix string) []string {
+	return []string{prefix + "diameter_px", prefix + "diameter_mm", prefix + "interpolated"}
+}
+
+// formatSample returns the CSV fields of s, other than its time.
+// Diameters are also written in millimeters if cal is calibrated. A
+// nil s, for a frame that's missing, has empty fields.
+func formatSample(s *pupilSample, cal location.Calibration) []string {
+	if s == nil {
+		return []string{"", "", ""}
+	}
+	px, mm := "", ""
+	if s.Diameter > 0 {
+		px = strconv.FormatFloat(s.Diameter, 'f', 2, 64)
+		if cal.Calibrated() {
+			mm = strconv.FormatFloat(cal.MM(s.Diameter), 'f', 3, 64)
+		}
+	}
+	return []string{px, mm, strconv.FormatBool(s.Interpolated)}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterpolateBlinks(t *testing.T) {
+	series := []pupilSample{
+		{Time: 0.0},
+		{Time: 0.1, Diameter: 40},
+		{Time: 0.2},
+		{Time: 0.3},
+		{Time: 0.5, Diameter: 60},
+		{Time: 0.6, Diameter: 62},
+		{Time: 0.7},
+	}
+	interpolateBlinks(series)
+
+	want := []pupilSample{
+		// Nothing to interpolate from before the first visible
+		// frame, or after the last.
+		{Time: 0.0},
+		{Time: 0.1, Diameter: 40},
+		// Interpolated by time, not by frame count.
+		{Time: 0.2, Diameter: 45, Interpolated: true},
+		{Time: 0.3, Diameter: 50, Interpolated: true},
+		{Time: 0.5, Diameter: 60},
+		{Time: 0.6, Diameter: 62},
+		{Time: 0.7},
+	}
+	for i := range want {
+		g, w := series[i], want[i]
+		if math.Abs(g.Diameter-w.Diameter) > 1e-9 || g.Interpolated != w.Interpolated {
+			t.Errorf("sample %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
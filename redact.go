@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+
+	"gocv.io/x/gocv"
+
+	"go.universe.tf/iris/internal/errs"
+	"go.universe.tf/iris/internal/location"
+)
+
+var (
+	redactOut  = flag.String("redact-out", "", "redact: file to write the redacted image to")
+	redactMode = flag.String("redact-mode", "blur", "redact: how to hide the iris texture (blur, mask)")
+)
+
+// redactMargin is how far past the estimated limbus redaction
+// extends, as a fraction of its radius. Leaving a sliver of iris
+// texture at the edge would defeat the point, so err on the side of
+// hiding a little sclera.
+const redactMargin = 0.1
+
+// redactMinBlur is the smallest blur kernel, in pixels, that redact
+// uses. Iris codes are made of texture a few pixels across at typical
+// capture resolutions, which survives a kernel sized off a small
+// pupil.
+const redactMinBlur = 31
+
+// redact hides the iris texture in the image named on the command
+// line, and writes the result to -redact-out. The pupil and
+// everything outside the iris are left alone, so the image is still
+// useful for debugging localization and for showing the periocular
+// region, but no longer carries usable biometric data.
+//
+// If the pupil can't be found confidently, nothing is written: an
+// unredacted image must never come out by accident.
+func redact(opts location.Options) error {
+	if flag.Arg(0) == "" || *redactOut == "" {
+		return errors.New("redact needs an input image and -redact-out")
+	}
+	if *redactMode != "blur" && *redactMode != "mask" {
+		return fmt.Errorf("unknown -redact-mode %q", *redactMode)
+	}
+
+	im := gocv.IMRead(flag.Arg(0), gocv.IMReadColor)
+	defer im.Close()
+	if im.Empty() {
+		return errs.New(errs.ErrUnsupportedFormat, "can't read image %q", flag.Arg(0))
+	}
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(im, &gray, gocv.ColorBGRToGray)
+
+	det := location.NewDetector(&opts)
+	res, err := det.Locate(context.Background(), gray)
+	if err != nil {
+		return fmt.Errorf("locating pupil: %w", err)
+	}
+	pupil := res.Pupil
+	if pupil.R == 0 {
+		return errs.New(errs.ErrNotFound, "no pupil found in %q, not redacting", flag.Arg(0))
+	}
+	// An unconfident pupil may be something else entirely, and
+	// redacting around it would leave the real iris in the clear.
+	if !res.Confident {
+		return errs.New(errs.ErrLowQuality, "pupil in %q found with confidence %.2f (reasons: %s), not redacting", flag.Arg(0), res.Confidence, reasonList(res.Reasons))
+	}
+
+	// Same guess as FindSclera, unless the segmentation model can
+	// do better.
+	outer := int(float64(pupil.R) * 3.5)
+	if opts.ModelPath != "" {
+		seg, err := location.Segment(gray, opts.ModelPath)
+		if err != nil {
+			log.Printf("segmenting for redaction, using the largest plausible iris: %v", err)
+		} else {
+			if limbus := seg.Limbus(); limbus.R > pupil.R {
+				outer = int(float64(limbus.R) * (1 + redactMargin))
+			}
+			seg.Close()
+		}
+	}
+
+	mask := gocv.NewMatWithSize(im.Rows(), im.Cols(), gocv.MatTypeCV8U)
+	defer mask.Close()
+	mask.SetTo(gocv.NewScalar(0, 0, 0, 0))
+	gocv.Circle(&mask, pupil.Point, outer, color.RGBA{255, 255, 255, 0}, -1)
+	gocv.Circle(&mask, pupil.Point, pupil.R, color.RGBA{0, 0, 0, 0}, -1)
+
+	var cover gocv.Mat
+	switch *redactMode {
+	case "blur":
+		// A kernel as wide as the iris ring wipes out the fine
+		// texture that iris codes are made of, while keeping the
+		// iris's overall shading, so the image still looks like an
+		// eye.
+		k := outer - pupil.R
+		if k < redactMinBlur {
+			k = redactMinBlur
+		}
+		k |= 1
+		cover = gocv.NewMat()
+		gocv.GaussianBlur(im, &cover, image.Point{k, k}, 0, 0, gocv.BorderDefault)
+	case "mask":
+		cover = gocv.NewMatWithSizeFromScalar(im.Mean(), im.Rows(), im.Cols(), gocv.MatTypeCV8UC3)
+	}
+	defer cover.Close()
+	cover.CopyToWithMask(&im, mask)
+
+	if !gocv.IMWrite(*redactOut, im) {
+		return fmt.Errorf("writing %s failed", *redactOut)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"math"
+
+	"go.universe.tf/iris/internal/location"
+)
+
+var (
+	video2  = flag.String("video2", "", "pupillometry: video file of the other eye, for rigs with a camera per eye")
+	shm2    = flag.String("shm2", "", "pupillometry: shared memory frame ring of the other eye, instead of -video2")
+	camera2 = flag.Int("camera2", -1, "pupillometry: camera device number of the other eye, instead of -video2")
+)
+
+// pairTolerance returns how far apart, in seconds, frames from
+// cameras running at fps1 and fps2 can be and still count as
+// simultaneous: half the slower camera's frame period.
+func pairTolerance(fps1, fps2 float64) float64 {
+	return 0.5 / math.Min(fps1, fps2)
+}
+
+// pairSeries pairs up the samples of a and b that are within tol
+// seconds of each other, in time order. Cameras drop frames
+// independently, so a sample with no partner, because the other
+// camera dropped that frame, is paired with nil.
+func pairSeries(a, b []pupilSample, tol float64) [][2]*pupilSample {
+	var ret [][2]*pupilSample
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i].Time < b[j].Time-tol):
+			ret = append(ret, [2]*pupilSample{&a[i], nil})
+			i++
+		case i == len(a) || b[j].Time < a[i].Time-tol:
+			ret = append(ret, [2]*pupilSample{nil, &b[j]})
+			j++
+		default:
+			ret = append(ret, [2]*pupilSample{&a[i], &b[j]})
+			i++
+			j++
+		}
+	}
+	return ret
+}
+
+// writeStereoSeries writes the series of both eyes as CSV to -out, or
+// stdout, one row per pair of simultaneous frames. The columns of an
+// eye whose camera dropped the frame are empty.
+func writeStereoSeries(series1, series2 []pupilSample, cal1, cal2 location.Calibration, tol float64) error {
+	header := []string{"time_s"}
+	header = append(header, sampleColumns("eye1_")...)
+	header = append(header, sampleColumns("eye2_")...)
+
+	var rows [][]string
+	for _, p := range pairSeries(series1, series2, tol) {
+		t := p[0]
+		if t == nil {
+			t = p[1]
+		}
+		row := []string{formatTime(t.Time)}
+		row = append(row, formatSample(p[0], cal1)...)
+		row = append(row, formatSample(p[1], cal2)...)
+		rows = append(rows, row)
+	}
+	return writeCSV(header, rows)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestPairSeries(t *testing.T) {
+	// Two 10 fps cameras, slightly out of phase. a drops its third
+	// frame, b its fourth.
+	a := []pupilSample{{Time: 0.00}, {Time: 0.10}, {Time: 0.30}, {Time: 0.40}}
+	b := []pupilSample{{Time: 0.01}, {Time: 0.11}, {Time: 0.21}, {Time: 0.41}}
+	got := pairSeries(a, b, pairTolerance(10, 10))
+
+	want := [][2]*pupilSample{
+		{&a[0], &b[0]},
+		{&a[1], &b[1]},
+		{nil, &b[2]},
+		{&a[2], nil},
+		{&a[3], &b[3]},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d pairs, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pair %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPairSeriesEmpty(t *testing.T) {
+	a := []pupilSample{{Time: 0}, {Time: 1}}
+	if got := pairSeries(nil, nil, 0.05); len(got) != 0 {
+		t.Errorf("pairSeries(nil, nil) = %v, want nothing", got)
+	}
+	got := pairSeries(a, nil, 0.05)
+	if len(got) != 2 || got[0] != [2]*pupilSample{&a[0], nil} || got[1] != [2]*pupilSample{&a[1], nil} {
+		t.Errorf("pairSeries(a, nil) = %v, want every sample of a alone", got)
+	}
+}
+
+func TestPairTolerance(t *testing.T) {
+	if got, want := pairTolerance(30, 60), 0.5/30; got != want {
+		t.Errorf("pairTolerance(30, 60) = %v, want %v", got, want)
+	}
+}